@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"wkey/internal/audio"
+	"wkey/internal/clipboard"
+	"wkey/internal/config"
+	"wkey/internal/control"
+	"wkey/internal/stt"
+	"wkey/internal/ui"
+)
+
+// app holds everything that outlives a single dictation: config, UI, recorder
+// and STT client. A one-shot instance runs a single session and quits; a daemon
+// keeps them warm and runs a new session for every start command.
+type app struct {
+	cfg    *config.Config
+	opts   options
+	daemon bool
+
+	ui          *ui.UI
+	recorder    *audio.Recorder
+	transcriber stt.Transcriber
+	sttErr      error
+	tmpFile     string
+	ready       chan struct{} // closed once the fields above are initialized
+
+	mu       sync.Mutex
+	sess     *session // current or most recent session, nil before the first one in daemon mode
+	exitCode int
+}
+
+func newApp(cfg *config.Config, opts options, daemon bool) *app {
+	a := &app{
+		cfg:    cfg,
+		opts:   opts,
+		daemon: daemon,
+		ready:  make(chan struct{}),
+	}
+	// A one-shot instance is recording from the start, so that a stop arriving
+	// while the UI is still initializing is not lost
+	if !daemon {
+		a.sess = newSession()
+	}
+	return a
+}
+
+// init creates the UI, recorder and STT client.
+func (a *app) init() {
+	defer close(a.ready)
+
+	// Init UI
+	a.ui = ui.New(a.cfg)
+	a.ui.SetOnCancel(func() {
+		fmt.Printf("[Main] Escape pressed, cancelling\n")
+		if sess := a.currentSession(); sess != nil {
+			sess.requestCancel()
+		}
+	})
+	if a.daemon {
+		a.ui.HideOnClose()
+	}
+
+	// Init Audio
+	format := recordingFormat(a.cfg)
+	// An invalid backend falls back to auto-detection rather than failing every session
+	backend, err := audio.NewBackend(a.cfg.Audio.Backend, audio.BackendOptions{
+		File:   a.cfg.Audio.File,
+		Device: a.cfg.Audio.Device,
+		Format: format,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, auto-detecting capture backend\n", err)
+	}
+	a.recorder = audio.NewRecorder(backend, format)
+	// Each visualizer bar shows one frequency band of the microphone input
+	a.recorder.SetSpectrum(a.cfg.Visual.BarCount, a.ui.SetSpectrum)
+	a.recorder.SetOnWarning(a.ui.ShowWarning)
+	a.tmpFile = filepath.Join(os.TempDir(), fmt.Sprintf("voice-input-%d.wav", os.Getpid()))
+	if a.opts.keepTemp {
+		fmt.Printf("Keeping temp file: %s\n", a.tmpFile)
+	}
+
+	if a.opts.verbose {
+		fmt.Printf("Recording to temp file: %s\n", a.tmpFile)
+	}
+
+	// Init STT
+	// We check the error in each session to allow the UI to show it
+	a.transcriber, a.sttErr = stt.New(a.cfg, stt.Options{
+		MockResponse: a.opts.mockResponse,
+		Verbose:      a.opts.verbose,
+		OnRetry:      a.onRetry,
+	})
+}
+
+// onRetry reports a failed transcription request that is about to be retried.
+func (a *app) onRetry(attempt, maxAttempts int, delay time.Duration, err error) {
+	fmt.Printf("[Logic] Transcription failed (%v), attempt %d/%d in %v\n", err, attempt, maxAttempts, delay.Round(time.Millisecond))
+	// Segments are also transcribed while still recording, which the UI must keep showing
+	if sess := a.currentSession(); sess != nil && sess.State() == control.StateTranscribing {
+		a.ui.ShowRetrying(attempt, maxAttempts)
+	}
+}
+
+// recordingFormat returns the configured recording format, or DefaultFormat if it is invalid.
+func recordingFormat(cfg *config.Config) audio.Format {
+	format := audio.Format{
+		SampleRate: cfg.Audio.SampleRate,
+		Channels:   cfg.Audio.Channels,
+		BitDepth:   cfg.Audio.BitDepth,
+	}
+	if err := format.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v, recording in %v\n", err, audio.DefaultFormat)
+		return audio.DefaultFormat
+	}
+	return format
+}
+
+func (a *app) currentSession() *session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sess
+}
+
+// startSessionLocked begins a new dictation. a.mu must be held.
+func (a *app) startSessionLocked() *session {
+	a.sess = newSession()
+	go a.runSession(a.sess)
+	return a.sess
+}
+
+// idle reports whether no session is in progress.
+func idle(sess *session) bool {
+	return sess == nil || sess.State() == control.StateDone
+}
+
+// handle answers a command received on the control socket.
+func (a *app) handle(req control.Request) control.Response {
+	<-a.ready
+
+	a.mu.Lock()
+	sess := a.sess
+	if !a.daemon || !idle(sess) {
+		a.mu.Unlock()
+		return sess.handle(req)
+	}
+	defer a.mu.Unlock()
+
+	// A daemon between sessions
+	resp := control.Response{OK: true, State: control.StateIdle, PID: os.Getpid()}
+	switch req.Command {
+	case control.CmdToggle, control.CmdStart:
+		a.startSessionLocked()
+		resp.State = control.StateStarting
+		resp.Message = "started"
+	case control.CmdStop, control.CmdCancel, control.CmdPause, control.CmdResume:
+		resp.Message = "not recording, ignored"
+	case control.CmdStatus:
+	default:
+		resp.OK = false
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+	}
+	return resp
+}
+
+// handleSignal maps process signals onto session commands.
+func (a *app) handleSignal(sig os.Signal) {
+	fmt.Printf("\n[Main] Received signal: %v\n", sig)
+
+	// USR2 cancels the recording without transcribing
+	if sig == syscall.SIGUSR2 {
+		if sess := a.currentSession(); sess != nil {
+			sess.requestCancel()
+		}
+		return
+	}
+
+	if a.daemon {
+		// USR1 toggles like the hotkey; INT and TERM shut the daemon down
+		if sig == syscall.SIGUSR1 {
+			a.handle(control.Request{Command: control.CmdToggle})
+			return
+		}
+		<-a.ready
+		if sess := a.currentSession(); sess != nil {
+			sess.requestCancel()
+			<-sess.done
+		}
+		a.ui.Quit()
+		return
+	}
+
+	// USR1 is kept as an alias of toggle for scripts written against the old PID file toggle
+	if sig == syscall.SIGUSR1 {
+		resp := a.currentSession().handle(control.Request{Command: control.CmdToggle})
+		fmt.Printf("[Main] toggle: %s%s\n", resp.Message, resp.Error)
+		return
+	}
+
+	// INT and TERM stop the recording so it still gets transcribed
+	if !a.currentSession().requestStop() {
+		fmt.Printf("[Main] Subsequent signal ignored to allow transcription to finish\n")
+	}
+}
+
+// wait blocks until the current session, if any, has finished.
+func (a *app) wait() {
+	if sess := a.currentSession(); sess != nil {
+		<-sess.done
+	}
+}
+
+// endSession closes the UI of a one-shot instance, or hides it until the next
+// session in daemon mode.
+func (a *app) endSession() {
+	if a.daemon {
+		a.ui.Hide()
+		return
+	}
+	a.ui.Quit()
+}
+
+// fail sets the exit code of a one-shot instance.
+func (a *app) fail(exitCode int) {
+	a.mu.Lock()
+	a.exitCode = exitCode
+	a.mu.Unlock()
+}
+
+// runSession drives a single dictation through the state machine:
+// Recording -> Transcribing -> Pasting -> Done.
+func (a *app) runSession(sess *session) {
+	defer close(sess.done)
+	defer sess.setState(control.StateDone)
+
+	cfg := a.cfg
+	u := a.ui
+	recorder := a.recorder
+	tmpFile := a.tmpFile
+
+	if !a.opts.keepTemp {
+		defer os.Remove(tmpFile)
+	}
+
+	if a.sttErr != nil {
+		fmt.Printf("[Logic] STT Client Init Error: %v\n", a.sttErr)
+		message, exitCode := describeSTTError(a.sttErr)
+		if exitCode == 1 {
+			message = "STT Init Failed"
+		}
+		u.ShowError(message)
+		u.Show()
+		a.fail(exitCode)
+		time.Sleep(3 * time.Second)
+		a.endSession()
+		return
+	}
+
+	// Focus Management State
+	var lastWindowOutput string
+
+	// Pre-recording: Capture current window if configured
+	if cfg.Focus.GetWindowCmd != "" {
+		fmt.Printf("[Focus] Executing GetWindowCmd: %s\n", cfg.Focus.GetWindowCmd)
+		out, err := exec.Command("sh", "-c", cfg.Focus.GetWindowCmd).Output()
+		if err != nil {
+			fmt.Printf("[Focus] GetWindowCmd failed: %v\n", err)
+		} else {
+			lastWindowOutput = strings.TrimSpace(string(out))
+			fmt.Printf("[Focus] Captured window info: %s\n", lastWindowOutput)
+		}
+	}
+
+	// Voice activity detection keeps per-session state, so start from a fresh detector
+	if cfg.Audio.VAD.Enabled {
+		vad := audio.NewVAD(
+			cfg.Audio.VAD.Threshold,
+			time.Duration(cfg.Audio.VAD.MinSpeechMs)*time.Millisecond,
+			time.Duration(cfg.Audio.VAD.SilenceMs)*time.Millisecond,
+		)
+		recorder.SetVAD(vad, func() {
+			fmt.Printf("[Main] End of speech detected, stopping\n")
+			sess.requestStop()
+		})
+	}
+
+	// Upload while recording, so only the end of the request is left when the user stops
+	var upload *streamUpload
+	if cfg.STT.Stream {
+		if streamer, ok := a.transcriber.(stt.StreamTranscriber); ok {
+			upload = startStreamUpload(sess.ctx, streamer, filepath.Base(tmpFile))
+		} else {
+			fmt.Printf("[Logic] STT provider %s cannot stream, uploading after recording\n", cfg.STT.Provider)
+		}
+	}
+
+	// Otherwise transcribe long recordings in segments as they are completed
+	var segments *segmentQueue
+	if cfg.Audio.Segment.Enabled && upload == nil {
+		segments = startSegmentQueue(sess.ctx, a, tmpFile)
+		recorder.SetSegments(audio.SegmentOptions{
+			Threshold: cfg.Audio.Segment.Threshold,
+			Silence:   time.Duration(cfg.Audio.Segment.SilenceMs) * time.Millisecond,
+			MinLength: time.Duration(cfg.Audio.Segment.MinLengthMs) * time.Millisecond,
+			MaxLength: time.Duration(cfg.Audio.Segment.MaxLengthMs) * time.Millisecond,
+		}, segments.add)
+	} else {
+		recorder.SetSegments(audio.SegmentOptions{}, nil)
+	}
+
+	// Start Recording
+	fmt.Printf("[Logic] Starting recording...\n")
+	u.SetElapsed(0)
+	u.ShowRecording()
+	var err error
+	if upload != nil {
+		// The file is only written when it is asked to be kept
+		keepFile := ""
+		if a.opts.keepTemp {
+			keepFile = tmpFile
+		}
+		err = recorder.StartStream(upload.pw, keepFile, nil)
+	} else {
+		err = recorder.Start(tmpFile, nil)
+	}
+	if err != nil {
+		if upload != nil {
+			upload.abort()
+		}
+		if segments != nil {
+			segments.abort()
+		}
+		fmt.Printf("[Logic] Recorder Start Error: %v\n", err)
+		u.ShowError("Rec Error: " + err.Error())
+		a.fail(1)
+		time.Sleep(3 * time.Second)
+		a.endSession()
+		return
+	}
+
+	sess.setState(control.StateRecording)
+
+	// Wait for Stop Signal or Timeout. Paused time does not count towards the limit.
+	maxDuration := time.Duration(cfg.MaxDurationMs) * time.Millisecond
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case <-sess.stopChan:
+			fmt.Printf("[Logic] Stop signal received via stopChan\n")
+			break wait
+		case <-sess.ctx.Done():
+			fmt.Printf("[Logic] Cancel signal received\n")
+			break wait
+		case <-sess.pauseChan:
+			if sess.pauseRequested() {
+				recorder.Pause()
+				sess.setState(control.StatePaused)
+				u.ShowPaused()
+			} else {
+				recorder.Resume()
+				sess.setState(control.StateRecording)
+				u.ShowRecording()
+			}
+		case <-ticker.C:
+			elapsed := recorder.Duration()
+			u.SetElapsed(elapsed)
+			if elapsed >= maxDuration {
+				fmt.Printf("[Logic] Recording timeout (%v) reached\n", maxDuration)
+				u.ShowError(fmt.Sprintf("Timeout (%v)", maxDuration))
+				// Proceed to stop and transcribe
+				break wait
+			}
+		}
+	}
+
+	// Stop Recording
+	fmt.Printf("[Logic] Stopping recorder...\n")
+	recorder.Stop()
+	fmt.Printf("[Logic] Recorder stopped. File size: %d bytes\n", getFileSize(tmpFile))
+
+	if sess.cancelled() {
+		fmt.Printf("[Logic] Cancelled, discarding recording\n")
+		if upload != nil {
+			upload.abort()
+		}
+		if segments != nil {
+			segments.abort()
+		}
+		os.Remove(tmpFile)
+		u.ShowCancelled("Cancelled")
+		time.Sleep(1 * time.Second)
+		a.endSession()
+		return
+	}
+
+	// Discard accidental taps instead of uploading near-empty audio
+	if minDuration := time.Duration(cfg.MinDurationMs) * time.Millisecond; recorder.Duration() < minDuration {
+		fmt.Printf("[Logic] Recording too short (%v < %v), discarding\n", recorder.Duration(), minDuration)
+		if upload != nil {
+			upload.abort()
+		}
+		if segments != nil {
+			segments.abort()
+		}
+		os.Remove(tmpFile)
+		u.ShowCancelled("Too short")
+		time.Sleep(1 * time.Second)
+		a.endSession()
+		return
+	}
+
+	// A muted microphone records digital silence, which would only come back as "No speech detected"
+	if stats := recorder.Stats(); cfg.Audio.RejectSilent && stats.Silent() {
+		fmt.Printf("[Logic] Recording is silent (%v), not uploading\n", stats)
+		if upload != nil {
+			upload.abort()
+		}
+		if segments != nil {
+			segments.abort()
+		}
+		os.Remove(tmpFile)
+		u.ShowError("No audio, is the microphone muted?")
+		a.fail(1)
+		time.Sleep(3 * time.Second)
+		a.endSession()
+		return
+	}
+
+	sess.setState(control.StateTranscribing)
+	u.ShowTranscribing()
+
+	// Transcribe
+	fmt.Printf("[Logic] Starting transcription...\n")
+	var text string
+	switch {
+	case upload != nil:
+		text, err = upload.finish()
+	case segments != nil:
+		text, err = segments.finish()
+	default:
+		text, err = a.transcribeFile(sess.ctx, tmpFile)
+	}
+
+	// A cancel aborts the request, so the error is only a consequence of it
+	if sess.cancelled() {
+		fmt.Printf("[Logic] Cancelled during transcription, discarding result\n")
+		os.Remove(tmpFile)
+		u.ShowCancelled("Cancelled")
+		time.Sleep(1 * time.Second)
+		a.endSession()
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("[Logic] Transcription Error: %v\n", err)
+		message, exitCode := describeSTTError(err)
+		u.ShowError(message)
+		a.fail(exitCode)
+		time.Sleep(3 * time.Second)
+		a.endSession()
+		return
+	}
+	fmt.Printf("[Logic] Transcription finished. Result: %q\n", text)
+
+	if text == "" {
+		u.ShowError("No speech detected")
+		time.Sleep(2 * time.Second)
+		a.endSession()
+		return
+	}
+
+	if cfg.History.Enabled {
+		saveHistory(cfg, text)
+	}
+
+	// Clipboard & Paste
+	sess.setState(control.StatePasting)
+	fmt.Printf("[Logic] Hiding UI to restore focus...\n")
+	u.Hide()
+
+	// Restore Focus if configured
+	if cfg.Focus.RestoreFocusCmd != "" && lastWindowOutput != "" {
+		restoreCmd := strings.ReplaceAll(cfg.Focus.RestoreFocusCmd, "{{.Output}}", lastWindowOutput)
+		fmt.Printf("[Focus] Executing RestoreFocusCmd: %s\n", restoreCmd)
+		if err := exec.Command("sh", "-c", restoreCmd).Run(); err != nil {
+			fmt.Printf("[Focus] RestoreFocusCmd failed: %v\n", err)
+		}
+	}
+
+	time.Sleep(600 * time.Millisecond) // Wait for focus to return to original window
+
+	fmt.Printf("[Logic] Copying text to clipboard and triggering paste...\n")
+	if err := clipboard.CopyToClipboard(text); err != nil {
+		fmt.Printf("[Logic] Clipboard Copy Failed: %v\n", err)
+	}
+
+	// Give wl-copy some time to register with the compositor before we trigger paste
+	time.Sleep(400 * time.Millisecond)
+
+	// Trigger paste shortcuts
+	if err := clipboard.Paste(); err != nil {
+		fmt.Printf("[Logic] Paste Trigger Failed: %v\n", err)
+	}
+
+	fmt.Printf("[Logic] Done.\n")
+	a.endSession()
+}
+
+// transcribeFile trims and compresses a finished recording or segment as
+// configured and transcribes it.
+func (a *app) transcribeFile(ctx context.Context, filename string) (string, error) {
+	return transcribeAudio(ctx, a.cfg, a.transcriber, filename, a.opts.keepTemp, os.Stdout)
+}
+
+// transcribeAudio runs a WAV file through the configured processing and
+// transcribes it with t. Processing is logged to logw.
+func transcribeAudio(ctx context.Context, cfg *config.Config, t stt.Transcriber, filename string, keepTemp bool, logw io.Writer) (string, error) {
+	uploadFile, cleanup := prepareUpload(cfg, filename, true, keepTemp, logw)
+	defer cleanup()
+	return t.Transcribe(ctx, uploadFile)
+}
+
+// prepareUpload trims silence from a WAV file in place, if enabled and trim is
+// set, and compresses it into the configured upload format. It returns the file
+// to upload and a function that removes it again unless keepTemp is set.
+// Trimming shifts timestamps, so it has to be skipped for subtitles.
+func prepareUpload(cfg *config.Config, filename string, trim, keepTemp bool, logw io.Writer) (string, func()) {
+	// Trim dead air before and after speech to cut upload size and hallucinations
+	if cfg.Audio.Trim.Enabled && trim {
+		margin := time.Duration(cfg.Audio.Trim.MarginMs) * time.Millisecond
+		removed, err := audio.TrimSilence(filename, cfg.Audio.Trim.Threshold, margin)
+		if err != nil {
+			fmt.Fprintf(logw, "[Logic] Trim Silence Failed: %v\n", err)
+		} else {
+			fmt.Fprintf(logw, "[Logic] Trimmed %v of silence. File size: %d bytes\n", removed, getFileSize(filename))
+		}
+	}
+
+	// Compress the upload to cut transfer time on slow connections
+	format := cfg.Audio.UploadFormat
+	if format == audio.UploadWAV {
+		return filename, func() {}
+	}
+	encoded, err := audio.Encode(filename, format)
+	if err != nil {
+		fmt.Fprintf(logw, "[Logic] Encoding to %s failed, uploading WAV: %v\n", format, err)
+		return filename, func() {}
+	}
+	fmt.Fprintf(logw, "[Logic] Encoded to %s. File size: %d bytes\n", format, getFileSize(encoded))
+	if keepTemp {
+		return encoded, func() {}
+	}
+	return encoded, func() { os.Remove(encoded) }
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"wkey/internal/audio"
+	"wkey/internal/config"
+	"wkey/internal/control"
+	"wkey/internal/history"
+	"wkey/internal/stt"
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage: wkey [flags] [command] [args]
+
+Commands:
+  toggle             Start a dictation, or stop the running one (default)
+  start              Start a dictation; does nothing if one is already recording
+  stop               Stop the running dictation and transcribe it
+  cancel             Abort the running dictation without transcribing
+  pause              Pause the running dictation without ending it
+  resume             Continue a paused dictation
+  status             Print the state of the running instance
+  transcribe FILE... Transcribe audio files and print the text or subtitles
+  history            Print recent transcriptions
+  devices            List audio input devices and their node names
+  config             Print the effective configuration
+  daemon             Stay resident with UI and microphone pre-warmed
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+// run dispatches a subcommand and returns the process exit code.
+func run(command string, args []string, cfg *config.Config, opts options) int {
+	switch command {
+	case control.CmdToggle, control.CmdStart:
+		return runInstance(command, cfg, opts)
+	case control.CmdStop, control.CmdCancel, control.CmdPause, control.CmdResume:
+		return runClient(command)
+	case control.CmdStatus:
+		return runStatus(args)
+	case "transcribe":
+		return runTranscribe(args, cfg, opts)
+	case "history":
+		return runHistory(args, cfg)
+	case "devices":
+		return runDevices(args, cfg)
+	case "config":
+		return runConfig(cfg)
+	case "daemon":
+		return runDaemon(cfg, opts)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n\n", command)
+		usage()
+		return 2
+	}
+}
+
+// sendCommand forwards a command to the running instance, prints its reply
+// and returns the process exit code.
+func sendCommand(socketPath string, command string) int {
+	resp, err := control.Send(socketPath, command)
+	return printReply(command, resp, err)
+}
+
+func printReply(command string, resp control.Response, err error) int {
+	if err != nil {
+		fmt.Printf("Failed to send %s: %v\n", command, err)
+		return 1
+	}
+	if !resp.OK {
+		fmt.Printf("%s: %s (state: %s)\n", command, resp.Error, resp.State)
+		return 1
+	}
+	fmt.Printf("%s: %s (state: %s)\n", command, resp.Message, resp.State)
+	return 0
+}
+
+// startupGrace is how long stop and cancel wait for an instance to come up, as
+// after a quick tap of a hold-to-talk key that runs start and stop almost together.
+const startupGrace = 500 * time.Millisecond
+
+// runClient sends a command that only makes sense for a running instance.
+// Having nothing to stop, cancel, pause or resume is not an error, so bindings can fire blindly.
+func runClient(command string) int {
+	socketPath := control.SocketPath()
+	resp, err := control.Send(socketPath, command)
+	// A start launched just before may not have claimed the socket yet, and a
+	// stop lost to that race would leave it recording until max_duration_ms
+	if command == control.CmdStop || command == control.CmdCancel {
+		deadline := time.Now().Add(startupGrace)
+		for errors.Is(err, control.ErrNotRunning) && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			resp, err = control.Send(socketPath, command)
+		}
+	}
+	if errors.Is(err, control.ErrNotRunning) {
+		fmt.Printf("%s: not running\n", command)
+		return 0
+	}
+	return printReply(command, resp, err)
+}
+
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the full reply as JSON")
+	fs.Parse(args)
+
+	resp, err := control.Send(control.SocketPath(), control.CmdStatus)
+	if errors.Is(err, control.ErrNotRunning) {
+		resp = control.Response{OK: true, State: control.StateIdle}
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to query status: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		data, _ := json.Marshal(resp)
+		fmt.Println(string(data))
+	} else {
+		fmt.Println(resp.State)
+	}
+	return 0
+}
+
+// Output formats of the transcribe command.
+const (
+	formatText = "text"
+	formatSRT  = "srt"
+	formatVTT  = "vtt"
+	formatJSON = "json"
+)
+
+func runTranscribe(args []string, cfg *config.Config, opts options) int {
+	fs := flag.NewFlagSet("transcribe", flag.ExitOnError)
+	output := fs.String("o", "", "Write the result to this file instead of stdout")
+	format := fs.String("format", formatText, "Output format: text, srt, vtt or json (with timestamps)")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: wkey transcribe [-format text|srt|vtt|json] [-o FILE] FILE...\n")
+		return 2
+	}
+	switch *format {
+	case formatText, formatSRT, formatVTT, formatJSON:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q (use text, srt, vtt or json)\n", *format)
+		return 2
+	}
+
+	// Subtitles of several files cannot share one output, so each goes next to its input
+	perFile := *format != formatText && fs.NArg() > 1
+	if perFile && *output != "" {
+		fmt.Fprintf(os.Stderr, "-o takes a single input file with -format %s\n", *format)
+		return 2
+	}
+
+	transcriber, err := stt.New(cfg, stt.Options{
+		MockResponse: opts.mockResponse,
+		Verbose:      opts.verbose,
+		OnRetry: func(attempt, maxAttempts int, delay time.Duration, err error) {
+			fmt.Fprintf(os.Stderr, "Transcription failed (%v), attempt %d/%d in %v\n", err, attempt, maxAttempts, delay.Round(time.Millisecond))
+		},
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "STT Client Init Error: %v\n", err)
+		_, exitCode := describeSTTError(err)
+		return exitCode
+	}
+	if _, ok := transcriber.(stt.TimestampTranscriber); !ok && *format != formatText {
+		fmt.Fprintf(os.Stderr, "STT provider %s cannot produce timestamps\n", cfg.STT.Provider)
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create output file: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		out = file
+	}
+
+	// Processing is only logged with --verbose, so that the text can be piped on
+	logw := io.Discard
+	if opts.verbose {
+		logw = os.Stderr
+	}
+
+	// Ctrl-C aborts the request in flight instead of waiting for it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	exitCode := 0
+	for _, filename := range fs.Args() {
+		if ctx.Err() != nil {
+			break
+		}
+		var buf bytes.Buffer
+		if err := transcribeInput(ctx, cfg, transcriber, filename, *format, &buf, opts.keepTemp, logw); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			_, exitCode = describeSTTError(err)
+			continue
+		}
+
+		if perFile {
+			path := strings.TrimSuffix(filename, filepath.Ext(filename)) + "." + *format
+			if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+				exitCode = 1
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "%s: wrote %s\n", filename, path)
+			continue
+		}
+		if _, err := out.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write output: %v\n", err)
+			return 1
+		}
+	}
+	return exitCode
+}
+
+// transcribeInput decodes an audio file into a temporary WAV file in the
+// recording format, transcribes it like a recording and writes the result to w.
+func transcribeInput(ctx context.Context, cfg *config.Config, t stt.Transcriber, filename, format string, w io.Writer, keepTemp bool, logw io.Writer) error {
+	tmp, err := os.CreateTemp("", "wkey-transcribe-*.wav")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmp.Close()
+	if keepTemp {
+		fmt.Fprintf(os.Stderr, "Keeping temp file: %s\n", tmp.Name())
+	} else {
+		defer os.Remove(tmp.Name())
+	}
+
+	if err := audio.Decode(filename, tmp.Name(), recordingFormat(cfg)); err != nil {
+		return err
+	}
+
+	if format == formatText {
+		text, err := transcribeAudio(ctx, cfg, t, tmp.Name(), keepTemp, logw)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, text)
+		return err
+	}
+
+	// Timestamps have to match the input, so silence is not trimmed
+	uploadFile, cleanup := prepareUpload(cfg, tmp.Name(), false, keepTemp, logw)
+	defer cleanup()
+	transcript, err := t.(stt.TimestampTranscriber).TranscribeTimestamps(ctx, uploadFile)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case formatSRT:
+		return transcript.WriteSRT(w)
+	case formatVTT:
+		return transcript.WriteVTT(w)
+	default:
+		return transcript.WriteJSON(w)
+	}
+}
+
+func runHistory(args []string, cfg *config.Config) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("n", 10, "Number of entries to print (0 for all)")
+	fs.Parse(args)
+
+	path, err := history.Path()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to locate history: %v\n", err)
+		return 1
+	}
+	entries, err := history.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(entries) == 0 && !cfg.History.Enabled {
+		fmt.Fprintf(os.Stderr, "History is disabled. Set \"history\": {\"enabled\": true} in the config file.\n")
+		return 0
+	}
+
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[len(entries)-*limit:]
+	}
+	for _, entry := range entries {
+		fmt.Printf("%s  %s\n", entry.Time.Local().Format("2006-01-02 15:04:05"), entry.Text)
+	}
+	return 0
+}
+
+func runDevices(args []string, cfg *config.Config) int {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	all := fs.Bool("all", false, "Also list monitors of output devices")
+	fs.Parse(args)
+
+	sources, err := audio.ListSources()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	// "*" marks the system default, ">" the device selected in the config
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  NAME\tDESCRIPTION\tSTATE\n")
+	for _, source := range sources {
+		if source.Monitor() && !*all {
+			continue
+		}
+		mark := " "
+		if source.Name == cfg.Audio.Device {
+			mark = ">"
+		} else if source.Default {
+			mark = "*"
+		}
+		fmt.Fprintf(w, "%s %s\t%s\t%s\n", mark, source.Name, source.Description, source.State)
+	}
+	w.Flush()
+
+	fmt.Fprintf(os.Stderr, "\nSet \"audio\": {\"device\": \"NAME\"} in the config file to record from a specific device.\n")
+	fmt.Fprintf(os.Stderr, "The ALSA backends (arecord, ffmpeg-alsa) need an ALSA PCM name instead, see arecord -L.\n")
+	return 0
+}
+
+func runConfig(cfg *config.Config) int {
+	if path, err := config.Path(); err == nil {
+		fmt.Fprintf(os.Stderr, "# %s\n", path)
+	}
+
+	// Never print secrets
+	masked := *cfg
+	masked.OpenAIAPIKey = maskKey(cfg.OpenAIAPIKey)
+
+	data, err := json.MarshalIndent(masked, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode config: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(data))
+	return 0
+}
+
+// maskKey hides all but the first and last few characters of an API key.
+func maskKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) <= 8 {
+		return strings.Repeat("*", len(key))
+	}
+	return key[:4] + "..." + key[len(key)-4:]
+}
+
+func saveHistory(cfg *config.Config, text string) {
+	path, err := history.Path()
+	if err != nil {
+		fmt.Printf("[History] Failed to locate history: %v\n", err)
+		return
+	}
+	entry := history.Entry{Time: time.Now(), Text: text, Provider: cfg.STT.Provider}
+	if err := history.Append(path, entry, cfg.History.MaxEntries); err != nil {
+		fmt.Printf("[History] Failed to save: %v\n", err)
+	}
+}
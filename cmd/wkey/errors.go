@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"wkey/internal/stt"
+)
+
+// Exit codes of a failed transcription, so that scripts can tell a wrong API
+// key from a server that is down. 1 is any other failure, 2 a usage error.
+const (
+	exitUnauthorized   = 3
+	exitRateLimited    = 4
+	exitQuotaExhausted = 5
+	exitTooLarge       = 6
+	exitNetwork        = 7
+	exitServer         = 8
+	exitBadRequest     = 9
+)
+
+// describeSTTError returns a short message for the UI and the exit code for a
+// failed transcription or STT client setup.
+func describeSTTError(err error) (string, int) {
+	status := ""
+	var apiErr *stt.APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode != 0 {
+		status = fmt.Sprintf(" (%d)", apiErr.StatusCode)
+	}
+
+	switch {
+	case errors.Is(err, stt.ErrMissingAPIKey):
+		return "Missing API Key", exitUnauthorized
+	case errors.Is(err, stt.ErrUnauthorized):
+		return "Invalid API Key", exitUnauthorized
+	case errors.Is(err, stt.ErrRateLimited):
+		return "Rate limited, try again later", exitRateLimited
+	case errors.Is(err, stt.ErrQuotaExhausted):
+		return "API quota exhausted", exitQuotaExhausted
+	case errors.Is(err, stt.ErrPayloadTooLarge):
+		return "Recording too large", exitTooLarge
+	case errors.Is(err, stt.ErrNetwork):
+		return "Network error, is the server reachable?", exitNetwork
+	case errors.Is(err, stt.ErrServer):
+		return "Server error" + status, exitServer
+	case errors.Is(err, stt.ErrBadRequest):
+		return "Request rejected" + status, exitBadRequest
+	}
+	return err.Error(), 1
+}
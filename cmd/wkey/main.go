@@ -1,36 +1,18 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"path/filepath"
-	"strconv"
-	"strings"
 	"syscall"
 	"time"
 
-	"os/exec"
-
-	"wkey/internal/audio"
-	"wkey/internal/clipboard"
 	"wkey/internal/config"
-	"wkey/internal/stt"
-	"wkey/internal/ui"
+	"wkey/internal/control"
 )
 
-const pidFileName = "voice-input.pid"
-
-func getPidFilePath() string {
-	// Use XDG_RUNTIME_DIR if available, else /tmp
-	dir := os.Getenv("XDG_RUNTIME_DIR")
-	if dir == "" {
-		dir = "/tmp"
-	}
-	return filepath.Join(dir, pidFileName)
-}
-
 func getFileSize(path string) int64 {
 	stat, err := os.Stat(path)
 	if err != nil {
@@ -39,202 +21,100 @@ func getFileSize(path string) int64 {
 	return stat.Size()
 }
 
-func main() {
-	pidFile := getPidFilePath()
+// options holds the global command line flags.
+type options struct {
+	keepTemp     bool
+	mockResponse string
+	verbose      bool
+}
 
+func main() {
 	// Parse flags
-	keepTemp := flag.Bool("keep-temp", false, "Do not delete the temporary recording file on exit")
-	mockResponse := flag.String("mock-response", "", "Force a specific mock response for STT")
-	verbose := flag.Bool("verbose", false, "Enable verbose output")
+	var opts options
+	flag.BoolVar(&opts.keepTemp, "keep-temp", false, "Do not delete the temporary recording file on exit")
+	flag.StringVar(&opts.mockResponse, "mock-response", "", "Force a specific mock response for STT")
+	flag.BoolVar(&opts.verbose, "verbose", false, "Enable verbose output")
+	flag.Usage = usage
 	flag.Parse()
 
+	command := control.CmdToggle
+	var args []string
+	if flag.NArg() > 0 {
+		command = flag.Arg(0)
+		args = flag.Args()[1:]
+	}
+
 	// Load Config
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		fmt.Printf("Warning: Failed to load config: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: Failed to load config: %v\n", err)
 	}
 
-	defer fmt.Println("[Main] Exiting main function")
-
-	// Setup Signal Handling EARLY to prevent race condition
-	stopChan := make(chan struct{})
-	doneChan := make(chan struct{}) // New: to ensure main waits for logic
-	sigChan := make(chan os.Signal, 1)
-	// Listen for INT, TERM (for actual kills) and USR1 (for our toggle)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1)
-
-	go func() {
-		first := true
-		for sig := range sigChan {
-			fmt.Printf("\n[Main] Received signal: %v\n", sig)
-			if sig == syscall.SIGUSR1 || sig == syscall.SIGTERM || sig == syscall.SIGINT {
-				if first {
-					fmt.Printf("[Main] Signaling stopChan...\n")
-					close(stopChan)
-					first = false
-				} else {
-					fmt.Printf("[Main] Subsequent signal ignored to allow transcription to finish\n")
-				}
-			}
-		}
-	}()
+	os.Exit(run(command, args, cfg, opts))
+}
 
-	// 1. Check for existing instance (Toggle Logic)
-	if content, err := os.ReadFile(pidFile); err == nil {
-		pidStr := strings.TrimSpace(string(content))
-		pid, err := strconv.Atoi(pidStr)
-		if err == nil {
-			// Process exists, send SIGUSR1 (less likely to be intercepted as "kill")
-			proc, err := os.FindProcess(pid)
-			if err == nil {
-				fmt.Printf("[Main] Signaling existing process %d with SIGUSR1\n", pid)
-				err := proc.Signal(syscall.SIGUSR1)
-				if err == nil {
-					// Successfully signaled, exit this instance
-					return
-				}
-			}
-		}
-		// If we are here, PID file exists but process might be dead. Clean up.
-		os.Remove(pidFile)
-	}
+// runInstance starts a dictation session in this process, or forwards command
+// to the instance that is already running. It returns the process exit code.
+func runInstance(command string, cfg *config.Config, opts options) int {
+	return serve(command, cfg, opts, false)
+}
 
-	// 2. Start New Instance
-	// Write PID
-	pid := os.Getpid()
-	fmt.Printf("[Main] Starting new instance (PID: %d)\n", pid)
-	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(pid)), 0644); err != nil {
-		fmt.Printf("Failed to write PID file: %v\n", err)
-		return
-	}
-	defer os.Remove(pidFile)
+// runDaemon keeps wkey resident with the UI, audio capture and STT client
+// pre-warmed, running a session for every start or toggle command.
+func runDaemon(cfg *config.Config, opts options) int {
+	return serve("", cfg, opts, true)
+}
 
-	// Init UI
-	u := ui.New(cfg)
+func serve(command string, cfg *config.Config, opts options, daemon bool) int {
+	a := newApp(cfg, opts, daemon)
 
-	// Init Audio
-	recorder := audio.NewRecorder()
-	tmpFile := filepath.Join(os.TempDir(), fmt.Sprintf("voice-input-%d.wav", pid))
-	if !*keepTemp {
-		defer os.Remove(tmpFile)
-	} else {
-		fmt.Printf("Keeping temp file: %s\n", tmpFile)
+	// 1. Claim the control socket, or hand the command to the running instance
+	socketPath := control.SocketPath()
+	server, err := control.Listen(socketPath, a.handle)
+	if errors.Is(err, control.ErrAlreadyRunning) {
+		if daemon {
+			fmt.Printf("Failed to start daemon: %v\n", err)
+			return 1
+		}
+		return sendCommand(socketPath, command)
 	}
-
-	if *verbose {
-		fmt.Printf("Recording to temp file: %s\n", tmpFile)
+	if err != nil {
+		fmt.Printf("Failed to create control socket: %v\n", err)
+		return 1
 	}
+	defer server.Close()
+	go server.Serve()
 
-	// Init STT
-	sttClient, err := stt.NewClient(cfg.OpenAIAPIKey, cfg.Language, *mockResponse, *verbose)
-	// We check err later in the goroutine to allow UI to show error
-
-	// Focus Management State
-	var lastWindowOutput string
+	defer fmt.Println("[Main] Exiting main function")
 
-	// Logic Goroutine
+	// Setup Signal Handling before any slow initialization
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2)
 	go func() {
-		if err != nil {
-			fmt.Printf("[Logic] STT Client Init Error: %v\n", err)
-			u.ShowError("Missing API Key")
-			time.Sleep(3 * time.Second)
-			u.Quit()
-			return
-		}
-
-		// Pre-recording: Capture current window if configured
-		if cfg.Focus.GetWindowCmd != "" {
-			fmt.Printf("[Focus] Executing GetWindowCmd: %s\n", cfg.Focus.GetWindowCmd)
-			out, err := exec.Command("sh", "-c", cfg.Focus.GetWindowCmd).Output()
-			if err != nil {
-				fmt.Printf("[Focus] GetWindowCmd failed: %v\n", err)
-			} else {
-				lastWindowOutput = strings.TrimSpace(string(out))
-				fmt.Printf("[Focus] Captured window info: %s\n", lastWindowOutput)
-			}
-		}
-
-		// Start Recording
-		fmt.Printf("[Logic] Starting recording...\n")
-		u.ShowRecording()
-		if err := recorder.Start(tmpFile, u.SetAudioLevel); err != nil {
-			fmt.Printf("[Logic] Recorder Start Error: %v\n", err)
-			u.ShowError("Rec Error: " + err.Error())
-			time.Sleep(3 * time.Second)
-			u.Quit()
-			return
-		}
-
-		// Wait for Stop Signal or Timeout (60s)
-		select {
-		case <-stopChan:
-			fmt.Printf("[Logic] Stop signal received via stopChan\n")
-		case <-time.After(60 * time.Second):
-			fmt.Printf("[Logic] Recording timeout (60s) reached\n")
-			u.ShowError("Timeout (60s)")
-			// Proceed to stop and transcribe
-		}
-
-		// Stop Recording
-		fmt.Printf("[Logic] Stopping recorder...\n")
-		recorder.Stop()
-		fmt.Printf("[Logic] Recorder stopped. File size: %d bytes\n", getFileSize(tmpFile))
-		u.ShowTranscribing()
-
-		// Transcribe
-		fmt.Printf("[Logic] Starting transcription...\n")
-		text, err := sttClient.Transcribe(tmpFile)
-		if err != nil {
-			fmt.Printf("[Logic] Transcription Error: %v\n", err)
-			u.ShowError(err.Error())
-			time.Sleep(3 * time.Second)
-			u.Quit()
-			return
-		}
-		fmt.Printf("[Logic] Transcription finished. Result: %q\n", text)
-
-		if text == "" {
-			u.ShowError("No speech detected")
-			time.Sleep(2 * time.Second)
-			u.Quit()
-			return
-		}
-
-		// Clipboard & Paste
-		fmt.Printf("[Logic] Hiding UI to restore focus...\n")
-		u.Hide()
-
-		// Restore Focus if configured
-		if cfg.Focus.RestoreFocusCmd != "" && lastWindowOutput != "" {
-			restoreCmd := strings.ReplaceAll(cfg.Focus.RestoreFocusCmd, "{{.Output}}", lastWindowOutput)
-			fmt.Printf("[Focus] Executing RestoreFocusCmd: %s\n", restoreCmd)
-			if err := exec.Command("sh", "-c", restoreCmd).Run(); err != nil {
-				fmt.Printf("[Focus] RestoreFocusCmd failed: %v\n", err)
-			}
-		}
-
-		time.Sleep(600 * time.Millisecond) // Wait for focus to return to original window
-
-		fmt.Printf("[Logic] Copying text to clipboard and triggering paste...\n")
-		if err := clipboard.CopyToClipboard(text); err != nil {
-			fmt.Printf("[Logic] Clipboard Copy Failed: %v\n", err)
+		for sig := range sigChan {
+			a.handleSignal(sig)
 		}
+	}()
 
-		// Give wl-copy some time to register with the compositor before we trigger paste
-		time.Sleep(400 * time.Millisecond)
-
-		// Trigger paste shortcuts
-		if err := clipboard.Paste(); err != nil {
-			fmt.Printf("[Logic] Paste Trigger Failed: %v\n", err)
+	// 2. Start New Instance
+	fmt.Printf("[Main] Starting new instance (PID: %d, socket: %s, daemon: %v)\n", os.Getpid(), socketPath, daemon)
+	a.init()
+
+	if daemon {
+		// Keep the microphone stream open so that recording starts instantly
+		a.recorder.SetPreRoll(time.Duration(cfg.Audio.PreRollMs) * time.Millisecond)
+		if err := a.recorder.Open(); err != nil {
+			fmt.Printf("[Main] Failed to open audio capture, will retry on start: %v\n", err)
 		}
+		defer a.recorder.Close()
+		fmt.Printf("[Main] Daemon ready\n")
+	} else {
+		go a.runSession(a.sess)
+	}
 
-		fmt.Printf("[Logic] Done. Quitting UI...\n")
-		u.Quit()
-		close(doneChan)
-	}()
-
-	u.Run()
+	a.ui.Run()
 	fmt.Println("[Main] u.Run() returned")
-	<-doneChan
-	fmt.Println("[Main] doneChan closed, exiting")
+	a.wait()
+	fmt.Println("[Main] Session finished, exiting")
+	return a.exitCode
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"wkey/internal/audio"
+)
+
+// maxQueuedSegments bounds the segments waiting for transcription. Beyond it,
+// further boundaries are skipped and the audio goes with the next segment.
+const maxQueuedSegments = 16
+
+// segmentQueue transcribes the segments of a long recording one after another
+// while the recording continues, so only the last one is left when it stops.
+type segmentQueue struct {
+	a      *app
+	ctx    context.Context
+	cancel context.CancelFunc
+	src    string
+	done   chan struct{}
+	texts  []string
+	err    error
+
+	mu     sync.Mutex // guards the fields below, shared with the recording goroutine
+	start  int64      // data offset of the segment being recorded
+	jobs   chan [2]int64
+	closed bool // jobs has been closed by finish or abort
+}
+
+// startSegmentQueue starts transcribing the segments of src. Cancelling ctx
+// aborts the transcription like abort.
+func startSegmentQueue(ctx context.Context, a *app, src string) *segmentQueue {
+	ctx, cancel := context.WithCancel(ctx)
+	q := &segmentQueue{
+		a:      a,
+		ctx:    ctx,
+		cancel: cancel,
+		src:    src,
+		jobs:   make(chan [2]int64, maxQueuedSegments),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// add queues the segment ending at data offset end. It is called from the
+// recording goroutine and never blocks it. Segments after finish or abort are ignored.
+func (q *segmentQueue) add(end int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.jobs <- [2]int64{q.start, end}:
+		q.start = end
+	default:
+		fmt.Printf("[Segment] Transcription is falling behind, merging with the next segment\n")
+	}
+}
+
+// finish queues the rest of the recording and waits for all segments to be
+// transcribed. The recording must have been stopped.
+func (q *segmentQueue) finish() (string, error) {
+	q.mu.Lock()
+	closed := q.closed
+	q.closed = true
+	q.mu.Unlock()
+	if !closed {
+		// add leaves jobs and start alone once closed is set, and run keeps
+		// draining jobs, so this does not need mu
+		q.jobs <- [2]int64{q.start, -1}
+		close(q.jobs)
+	}
+	<-q.done
+	q.cancel()
+	return joinTranscripts(q.texts), q.err
+}
+
+// abort discards the segments that have not been transcribed yet and
+// interrupts the one being transcribed.
+func (q *segmentQueue) abort() {
+	q.cancel()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+}
+
+func (q *segmentQueue) run() {
+	defer close(q.done)
+	n := 0
+	for job := range q.jobs {
+		// After an error the result is incomplete anyway, so stop spending requests
+		if q.ctx.Err() != nil || q.err != nil {
+			continue
+		}
+		n++
+		text, err := q.transcribe(n, job[0], job[1])
+		if err != nil {
+			q.err = fmt.Errorf("segment %d: %w", n, err)
+			continue
+		}
+		q.texts = append(q.texts, text)
+	}
+}
+
+func (q *segmentQueue) transcribe(n int, start, end int64) (string, error) {
+	filename := fmt.Sprintf("%s-%03d.wav", strings.TrimSuffix(q.src, ".wav"), n)
+	if !q.a.opts.keepTemp {
+		defer os.Remove(filename)
+	}
+	duration, err := audio.ExtractSegment(q.src, filename, start, end)
+	if err != nil {
+		return "", err
+	}
+	// Stopping right after a boundary leaves a sliver that is not worth a request
+	if minDuration := time.Duration(q.a.cfg.MinDurationMs) * time.Millisecond; duration < minDuration {
+		fmt.Printf("[Segment] Skipping segment %d (%v < %v)\n", n, duration, minDuration)
+		return "", nil
+	}
+	fmt.Printf("[Segment] Transcribing segment %d (%v)\n", n, duration.Round(time.Millisecond))
+	text, err := q.a.transcribeFile(q.ctx, filename)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("[Segment] Segment %d: %q\n", n, text)
+	return text, nil
+}
+
+// joinTranscripts stitches the texts of consecutive segments together. Scripts
+// written without spaces between words, like Chinese and Japanese, are joined directly.
+func joinTranscripts(texts []string) string {
+	var b strings.Builder
+	for _, text := range texts {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			last, _ := utf8.DecodeLastRuneInString(b.String())
+			first, _ := utf8.DecodeRuneInString(text)
+			if !unspaced(last) && !unspaced(first) {
+				b.WriteByte(' ')
+			}
+		}
+		b.WriteString(text)
+	}
+	return b.String()
+}
+
+// unspaced reports whether r belongs to a script or punctuation that is not
+// separated from its neighbours by spaces.
+func unspaced(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Thai) ||
+		(r >= 0x3000 && r <= 0x303F) || // CJK symbols and punctuation
+		(r >= 0xFF00 && r <= 0xFFEF) // fullwidth forms
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"wkey/internal/control"
+)
+
+// session tracks the state of the current dictation. It is shared between the
+// logic goroutine, which drives the state machine, and the control socket handler.
+type session struct {
+	mu     sync.Mutex
+	state  string
+	paused bool // pause requested, applied by the logic goroutine
+
+	// ctx is cancelled by requestCancel, which aborts a transcription in flight
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stopChan   chan struct{}
+	pauseChan  chan struct{} // signaled when the requested pause state changes
+	done       chan struct{} // closed when the logic goroutine has finished
+	stopOnce   sync.Once
+	cancelOnce sync.Once
+}
+
+func newSession() *session {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &session{
+		state:     control.StateStarting,
+		ctx:       ctx,
+		cancel:    cancel,
+		stopChan:  make(chan struct{}),
+		pauseChan: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *session) State() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *session) setState(state string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+	fmt.Printf("[Session] State: %s\n", state)
+}
+
+// requestStop ends the recording so it gets transcribed.
+// It returns false if a stop was already requested.
+func (s *session) requestStop() bool {
+	stopped := false
+	s.stopOnce.Do(func() {
+		fmt.Printf("[Session] Signaling stopChan...\n")
+		close(s.stopChan)
+		stopped = true
+	})
+	return stopped
+}
+
+// requestCancel aborts the session without transcribing or pasting.
+// It returns false if a cancel was already requested.
+func (s *session) requestCancel() bool {
+	cancelled := false
+	s.cancelOnce.Do(func() {
+		fmt.Printf("[Session] Cancelling session...\n")
+		s.cancel()
+		cancelled = true
+	})
+	return cancelled
+}
+
+// requestPause asks the logic goroutine to pause or resume the recording.
+// It returns false if that was already requested.
+func (s *session) requestPause(paused bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused == paused {
+		return false
+	}
+	s.paused = paused
+	select {
+	case s.pauseChan <- struct{}{}:
+	default:
+		// A change is already pending, the logic goroutine reads the latest request
+	}
+	return true
+}
+
+// pauseRequested reports whether the recording should currently be paused.
+func (s *session) pauseRequested() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// cancelled reports whether the session has been cancelled.
+func (s *session) cancelled() bool {
+	return s.ctx.Err() != nil
+}
+
+// isRecording reports whether state is one in which stop and toggle end the recording.
+func isRecording(state string) bool {
+	return state == control.StateStarting || state == control.StateRecording || state == control.StatePaused
+}
+
+// handle answers a command received on the control socket.
+func (s *session) handle(req control.Request) control.Response {
+	state := s.State()
+	resp := control.Response{OK: true, State: state, PID: os.Getpid()}
+
+	switch req.Command {
+	case control.CmdToggle, control.CmdStop:
+		// Pressing the hotkey again while waiting for the transcription gives up on it
+		if req.Command == control.CmdToggle && state == control.StateTranscribing {
+			s.requestCancel()
+			resp.Message = "cancelled"
+			return resp
+		}
+		if !isRecording(state) {
+			resp.Message = "not recording, ignored"
+			return resp
+		}
+		if s.requestStop() {
+			resp.Message = "stopping"
+		} else {
+			resp.Message = "already stopping"
+		}
+	case control.CmdStart:
+		if !isRecording(state) {
+			resp.OK = false
+			resp.Error = "busy: " + state
+			return resp
+		}
+		resp.Message = "already recording"
+	case control.CmdPause, control.CmdResume:
+		if state != control.StateRecording && state != control.StatePaused {
+			resp.OK = false
+			resp.Error = "not recording: " + state
+			return resp
+		}
+		pause := req.Command == control.CmdPause
+		changed := s.requestPause(pause)
+		switch {
+		case pause && changed:
+			resp.Message = "pausing"
+		case pause:
+			resp.Message = "already paused"
+		case changed:
+			resp.Message = "resuming"
+		default:
+			resp.Message = "not paused"
+		}
+	case control.CmdCancel:
+		if state == control.StatePasting || state == control.StateDone {
+			resp.OK = false
+			resp.Error = "too late to cancel: " + state
+			return resp
+		}
+		s.requestCancel()
+		resp.Message = "cancelled"
+	case control.CmdStatus:
+	default:
+		resp.OK = false
+		resp.Error = fmt.Sprintf("unknown command %q", req.Command)
+	}
+	return resp
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"wkey/internal/stt"
+)
+
+var (
+	errUploadAborted = errors.New("recording discarded")
+	errUploadEnded   = errors.New("upload already finished")
+)
+
+// streamUpload feeds a recording in progress to a StreamTranscriber through a
+// pipe, so the audio is uploaded while it is recorded and never touches disk.
+type streamUpload struct {
+	pw   *io.PipeWriter
+	done chan struct{}
+	text string
+	err  error
+}
+
+func startStreamUpload(ctx context.Context, t stt.StreamTranscriber, filename string) *streamUpload {
+	pr, pw := io.Pipe()
+	s := &streamUpload{pw: pw, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		s.text, s.err = t.TranscribeStream(ctx, pr, filename)
+		// If the request ended early, make further writes fail instead of blocking the recorder
+		pr.CloseWithError(errUploadEnded)
+	}()
+	return s
+}
+
+// finish ends the audio stream and waits for the transcription.
+func (s *streamUpload) finish() (string, error) {
+	s.pw.Close()
+	<-s.done
+	return s.text, s.err
+}
+
+// abort fails the upload so that the server discards the partial recording.
+func (s *streamUpload) abort() {
+	s.pw.CloseWithError(errUploadAborted)
+}
@@ -0,0 +1,315 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Capture is a running audio capture delivering raw PCM.
+type Capture interface {
+	io.Reader
+	// Format returns the format of the PCM delivered by Read. It may differ from
+	// the requested one, in which case the Recorder converts it.
+	Format() Format
+	// Stop asks the capture to end. Read returns io.EOF once the remaining audio is drained.
+	Stop() error
+	// Wait releases the capture after its output has been drained and reports how it ended.
+	Wait() error
+}
+
+// Backend starts audio captures.
+type Backend interface {
+	Name() string
+	Start() (Capture, error)
+}
+
+// Backend names accepted by NewBackend.
+const (
+	BackendAuto        = "auto"
+	BackendPWRecord    = "pw-record"
+	BackendParecord    = "parecord"
+	BackendArecord     = "arecord"
+	BackendFFmpegPulse = "ffmpeg-pulse"
+	BackendFFmpegALSA  = "ffmpeg-alsa"
+	BackendFile        = "file"
+)
+
+// BackendOptions configures a capture backend.
+type BackendOptions struct {
+	// File is the WAV or raw PCM file played back by BackendFile ("-" for stdin).
+	File string
+	// Device is the source to capture from, as listed by ListSources. The ALSA
+	// backends take an ALSA PCM name instead, which ListSources does not list.
+	// Empty means the system default.
+	Device string
+	// Format is the format to capture in. Zero means DefaultFormat.
+	Format Format
+}
+
+// NewBackend returns the capture backend with the given name.
+func NewBackend(name string, opts BackendOptions) (Backend, error) {
+	if opts.Format == (Format{}) {
+		opts.Format = DefaultFormat
+	}
+	if err := opts.Format.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "", BackendAuto:
+		return autoBackend{opts: opts}, nil
+	case BackendFile:
+		if opts.File == "" {
+			return nil, fmt.Errorf("file backend needs an input file")
+		}
+		return fileBackend{path: opts.File, format: opts.Format}, nil
+	}
+	for _, b := range commandBackends(opts) {
+		if b.name == name {
+			return b, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown audio backend %q", name)
+}
+
+// commandBackends lists the external capture tools, in auto-detection order.
+// They are asked to deliver opts.Format directly where they support it.
+func commandBackends(opts BackendOptions) []commandBackend {
+	f := opts.Format
+	rate := strconv.Itoa(f.SampleRate)
+	channels := strconv.Itoa(f.Channels)
+	bits := strconv.Itoa(f.BitDepth)
+
+	// pw-record has no packed 24-bit format, so capture 32-bit and let the Recorder convert
+	pwFormat := f
+	if pwFormat.BitDepth == 24 {
+		pwFormat.BitDepth = 32
+	}
+
+	// pw-record --format=s16 --rate=16000 --channels=1 -
+	// We output to stdout (-) to capture data
+	pwRecord := []string{"--format=s" + strconv.Itoa(pwFormat.BitDepth), "--rate=" + rate, "--channels=" + channels}
+	parecord := []string{"--raw", "--format=s" + bits + "le", "--rate=" + rate, "--channels=" + channels}
+	arecord := []string{"-q", "-t", "raw", "-f", alsaFormat(f.BitDepth), "-r", rate, "-c", channels}
+	if opts.Device != "" {
+		pwRecord = append(pwRecord, "--target="+opts.Device)
+		parecord = append(parecord, "--device="+opts.Device)
+		arecord = append(arecord, "-D", opts.Device)
+	}
+	pwRecord = append(pwRecord, "-")
+
+	return []commandBackend{
+		{name: BackendPWRecord, bin: "pw-record", args: pwRecord, format: pwFormat},
+		{name: BackendParecord, bin: "parecord", args: parecord, format: f},
+		{name: BackendArecord, bin: "arecord", args: arecord, format: f},
+		{name: BackendFFmpegPulse, bin: "ffmpeg", args: ffmpegArgs("pulse", opts), format: f},
+		{name: BackendFFmpegALSA, bin: "ffmpeg", args: ffmpegArgs("alsa", opts), format: f},
+	}
+}
+
+// alsaFormat returns the arecord sample format for a bit depth.
+// 24-bit samples are packed into 3 bytes, like the other tools deliver them.
+func alsaFormat(bitDepth int) string {
+	switch bitDepth {
+	case 24:
+		return "S24_3LE"
+	case 32:
+		return "S32_LE"
+	default:
+		return "S16_LE"
+	}
+}
+
+func ffmpegArgs(inputFormat string, opts BackendOptions) []string {
+	device := opts.Device
+	if device == "" {
+		device = "default"
+	}
+	return []string{
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-f", inputFormat, "-i", device,
+		"-ac", strconv.Itoa(opts.Format.Channels),
+		"-ar", strconv.Itoa(opts.Format.SampleRate),
+		"-f", fmt.Sprintf("s%dle", opts.Format.BitDepth), "-",
+	}
+}
+
+// autoBackend uses the first capture tool found in PATH.
+type autoBackend struct {
+	opts BackendOptions
+}
+
+func (autoBackend) Name() string { return BackendAuto }
+
+func (b autoBackend) Start() (Capture, error) {
+	var tried []string
+	for _, b := range commandBackends(b.opts) {
+		if _, err := exec.LookPath(b.bin); err != nil {
+			tried = append(tried, b.name)
+			continue
+		}
+		fmt.Printf("[Recorder] Auto-detected capture backend: %s\n", b.name)
+		return b.Start()
+	}
+	return nil, fmt.Errorf("no audio capture tool found (tried %s)", strings.Join(tried, ", "))
+}
+
+// commandBackend captures from an external tool writing raw PCM to stdout.
+type commandBackend struct {
+	name   string
+	bin    string
+	args   []string
+	format Format
+}
+
+func (b commandBackend) Name() string { return b.name }
+
+func (b commandBackend) Start() (Capture, error) {
+	// Check if the tool is available
+	if _, err := exec.LookPath(b.bin); err != nil {
+		return nil, fmt.Errorf("%s not found: %w", b.bin, err)
+	}
+
+	cmd := exec.Command(b.bin, b.args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", b.bin, err)
+	}
+	fmt.Printf("[Recorder] Started %s (PID: %d)\n", b.bin, cmd.Process.Pid)
+	return &commandCapture{cmd: cmd, stdout: stdout, format: b.format}, nil
+}
+
+type commandCapture struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	format Format
+}
+
+func (c *commandCapture) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *commandCapture) Format() Format { return c.format }
+
+// Stop sends SIGINT so the tool flushes its remaining output and exits.
+func (c *commandCapture) Stop() error {
+	fmt.Printf("[Recorder] Sending SIGINT to %s (PID: %d)...\n", c.cmd.Path, c.cmd.Process.Pid)
+	if err := c.cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("failed to send interrupt signal: %w", err)
+	}
+	return nil
+}
+
+func (c *commandCapture) Wait() error {
+	return c.cmd.Wait()
+}
+
+// fileBackend plays back a recorded WAV or raw PCM file, or stdin, as if it
+// were a microphone. It is meant for driving wkey from recorded fixtures.
+type fileBackend struct {
+	path   string
+	format Format // format of raw PCM input; WAV files carry their own
+}
+
+func (b fileBackend) Name() string { return BackendFile }
+
+func (b fileBackend) Start() (Capture, error) {
+	var file io.ReadCloser
+	if b.path == "-" {
+		// A read from stdin blocks until the writer sends more or closes it, so it
+		// goes through a pipe that Stop can close. The copy is abandoned then, and
+		// ends with the next read from stdin.
+		pr, pw := io.Pipe()
+		stdin := os.Stdin
+		go func() {
+			_, err := io.Copy(pw, stdin)
+			pw.CloseWithError(err)
+		}()
+		file = pr
+	} else {
+		f, err := os.Open(b.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audio file: %w", err)
+		}
+		file = f
+	}
+
+	c := &fileCapture{file: file, format: b.format, stopped: make(chan struct{})}
+	if err := c.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+type fileCapture struct {
+	file     io.ReadCloser // the file, or a pipe fed from stdin
+	reader   io.Reader
+	format   Format
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// readHeader positions the reader at the PCM data of a WAV file and takes the
+// format from its header. Files without a RIFF header are treated as raw PCM.
+func (c *fileCapture) readHeader() error {
+	head := make([]byte, 4)
+	n, err := io.ReadFull(c.file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("failed to read audio file: %w", err)
+	}
+	if string(head[:n]) != "RIFF" {
+		c.reader = io.MultiReader(bytes.NewReader(head[:n]), c.file)
+		return nil
+	}
+
+	format, _, err := readWavHeader(io.MultiReader(bytes.NewReader(head), c.file))
+	if err != nil {
+		return err
+	}
+	c.format = format
+	c.reader = c.file
+	return nil
+}
+
+func (c *fileCapture) Format() Format { return c.format }
+
+func (c *fileCapture) Read(p []byte) (int, error) {
+	select {
+	case <-c.stopped:
+		return 0, io.EOF
+	default:
+	}
+	n, err := c.reader.Read(p)
+	if err == io.ErrClosedPipe {
+		// Stop closed the pipe from stdin
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (c *fileCapture) Stop() error {
+	c.stopOnce.Do(func() {
+		close(c.stopped)
+		if pipe, ok := c.file.(*io.PipeReader); ok {
+			pipe.Close()
+		}
+	})
+	return nil
+}
+
+func (c *fileCapture) Wait() error {
+	return c.file.Close()
+}
@@ -0,0 +1,102 @@
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Decode converts the audio file src into a WAV file dst in format f, so that it
+// can be processed like a recording. PCM WAV files are converted in Go; anything
+// else (MP3, M4A, Opus, video files, ...) is decoded with ffmpeg if it is installed.
+func Decode(src, dst string, f Format) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open audio file: %w", err)
+	}
+	defer in.Close()
+
+	reader := bufio.NewReader(in)
+	format, size, wavErr := readWavHeader(reader)
+	if wavErr != nil {
+		return decodeFFmpeg(src, dst, f, wavErr)
+	}
+
+	var data io.Reader = reader
+	if size != streamDataSize {
+		data = io.LimitReader(reader, int64(size))
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create WAV file: %w", err)
+	}
+	defer out.Close()
+
+	// Long recordings do not fit in memory as samples, so convert them chunk by
+	// chunk and fill in the data size at the end
+	w := bufio.NewWriter(out)
+	writeWavHeader(w, f, streamDataSize)
+	var conv *converter
+	if format != f {
+		conv = newConverter(format, f)
+	}
+	block := format.BlockAlign()
+	buf := make([]byte, max(format.BytesPerSec()/block, 1)*block)
+	var written uint32
+	var pending []byte
+	for {
+		n, err := data.Read(buf)
+		if n > 0 {
+			// Reads can end mid-frame, so carry partial frames over to the next chunk
+			pending = append(pending, buf[:n]...)
+			whole := len(pending) - len(pending)%block
+			chunk := pending[:whole]
+			if conv != nil {
+				chunk = conv.Convert(chunk)
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return fmt.Errorf("failed to write WAV file: %w", err)
+			}
+			written += uint32(len(chunk))
+			pending = append(pending[:0], pending[whole:]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read audio file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write WAV file: %w", err)
+	}
+
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to write WAV file: %w", err)
+	}
+	writeWavHeader(out, f, written)
+	return nil
+}
+
+// decodeFFmpeg decodes src with ffmpeg. wavErr explains why src could not be
+// read as a WAV file, for when ffmpeg is not available.
+func decodeFFmpeg(src, dst string, f Format, wavErr error) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("cannot decode %s (%v), install ffmpeg for formats other than PCM WAV", src, wavErr)
+	}
+	cmd := exec.Command("ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin", "-y",
+		"-i", src, "-vn",
+		"-ac", fmt.Sprint(f.Channels),
+		"-ar", fmt.Sprint(f.SampleRate),
+		"-c:a", fmt.Sprintf("pcm_s%dle", f.BitDepth),
+		"-f", "wav", dst)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("ffmpeg failed to decode %s: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDecodeWAV(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "input.wav")
+	// Longer than one chunk of the decoder, to cover the state carried across chunks
+	in := Format{SampleRate: 48000, Channels: 2, BitDepth: 24}
+	if err := os.WriteFile(src, toneFixture(in, 2500*time.Millisecond), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "output.wav")
+	if err := Decode(src, dst, DefaultFormat); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, size, err := readWavHeader(bytes.NewReader(decoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != DefaultFormat {
+		t.Errorf("format = %v, want %v", format, DefaultFormat)
+	}
+	if int(size) != len(decoded)-wavHeaderSize {
+		t.Errorf("data size in header = %d, want %d", size, len(decoded)-wavHeaderSize)
+	}
+	if d := DefaultFormat.Duration(int64(size)); d < 2490*time.Millisecond || d > 2500*time.Millisecond {
+		t.Errorf("decoded %v, want 2.5s", d)
+	}
+}
+
+// extensibleHeader returns the header of a WAVE_FORMAT_EXTENSIBLE file whose
+// subformat carries tag.
+func extensibleHeader(tag uint16, f Format) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(streamDataSize))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(40))
+	binary.Write(&b, binary.LittleEndian, uint16(0xFFFE))
+	binary.Write(&b, binary.LittleEndian, uint16(f.Channels))
+	binary.Write(&b, binary.LittleEndian, uint32(f.SampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(f.BytesPerSec()))
+	binary.Write(&b, binary.LittleEndian, uint16(f.BlockAlign()))
+	binary.Write(&b, binary.LittleEndian, uint16(f.BitDepth))
+	binary.Write(&b, binary.LittleEndian, uint16(22))         // cbSize
+	binary.Write(&b, binary.LittleEndian, uint16(f.BitDepth)) // valid bits
+	binary.Write(&b, binary.LittleEndian, uint32(3))          // channel mask
+	binary.Write(&b, binary.LittleEndian, tag)
+	b.Write(pcmSubformatGUID[2:])
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(0))
+	return b.Bytes()
+}
+
+func TestReadWavHeaderExtensible(t *testing.T) {
+	f := Format{SampleRate: 48000, Channels: 2, BitDepth: 32}
+
+	format, _, err := readWavHeader(bytes.NewReader(extensibleHeader(1, f)))
+	if err != nil || format != f {
+		t.Errorf("PCM subformat: got %v, %v, want %v", format, err, f)
+	}
+	// IEEE float samples would be read as integers and come out as noise
+	if _, _, err := readWavHeader(bytes.NewReader(extensibleHeader(3, f))); err == nil {
+		t.Errorf("float subformat accepted as PCM")
+	}
+}
@@ -0,0 +1,80 @@
+package audio
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Source is an audio input known to PipeWire or PulseAudio.
+type Source struct {
+	Name        string `json:"name"` // node name, usable as audio.device
+	Description string `json:"description"`
+	State       string `json:"state"`
+	Default     bool   `json:"-"`
+}
+
+// Monitor reports whether the source records the output of a sink rather than a microphone.
+func (s Source) Monitor() bool {
+	return strings.HasSuffix(s.Name, ".monitor")
+}
+
+// ListSources returns the audio sources reported by pactl, which works with
+// both PulseAudio and PipeWire (through pipewire-pulse).
+func ListSources() ([]Source, error) {
+	if _, err := exec.LookPath("pactl"); err != nil {
+		return nil, fmt.Errorf("pactl not found: %w", err)
+	}
+
+	sources, err := listSourcesJSON()
+	if err != nil {
+		// pactl before version 16 has no JSON output
+		sources, err = listSourcesShort()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if out, err := exec.Command("pactl", "get-default-source").Output(); err == nil {
+		def := strings.TrimSpace(string(out))
+		for i := range sources {
+			sources[i].Default = sources[i].Name == def
+		}
+	}
+	return sources, nil
+}
+
+func listSourcesJSON() ([]Source, error) {
+	out, err := exec.Command("pactl", "--format=json", "list", "sources").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sources: %w", err)
+	}
+	var sources []Source
+	if err := json.Unmarshal(out, &sources); err != nil {
+		return nil, fmt.Errorf("failed to parse pactl output: %w", err)
+	}
+	return sources, nil
+}
+
+func listSourcesShort() ([]Source, error) {
+	out, err := exec.Command("pactl", "list", "short", "sources").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sources: %w", err)
+	}
+
+	// Columns: index, name, driver, sample spec, state
+	var sources []Source
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 2 {
+			continue
+		}
+		source := Source{Name: fields[1]}
+		if len(fields) >= 5 {
+			source.State = fields[4]
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
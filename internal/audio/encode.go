@@ -0,0 +1,102 @@
+package audio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Upload formats accepted by Encode.
+const (
+	UploadWAV  = "wav"
+	UploadFLAC = "flac"
+	UploadOpus = "opus"
+	UploadMP3  = "mp3"
+)
+
+// Encode compresses a WAV file written by Recorder into format and returns the
+// name of the encoded file, which sits next to the original with the matching
+// extension. FLAC is encoded in Go; Opus and MP3 need opusenc, ffmpeg or lame.
+// For UploadWAV filename is returned unchanged.
+func Encode(filename, format string) (string, error) {
+	base := strings.TrimSuffix(filename, ".wav")
+	switch format {
+	case "", UploadWAV:
+		return filename, nil
+	case UploadFLAC:
+		out := base + ".flac"
+		return out, encodeFLACFile(filename, out)
+	case UploadOpus:
+		// Ogg is the container the transcription APIs accept for Opus
+		out := base + ".ogg"
+		return out, runEncoder(out,
+			[]string{"opusenc", "--quiet", "--bitrate", "24", filename, out},
+			[]string{"ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", filename, "-c:a", "libopus", "-b:a", "24k", out},
+		)
+	case UploadMP3:
+		out := base + ".mp3"
+		return out, runEncoder(out,
+			[]string{"ffmpeg", "-hide_banner", "-loglevel", "error", "-nostdin", "-y", "-i", filename, "-c:a", "libmp3lame", "-b:a", "48k", out},
+			[]string{"lame", "--quiet", "-b", "48", filename, out},
+		)
+	default:
+		return "", fmt.Errorf("unknown upload format %q (use wav, flac, opus or mp3)", format)
+	}
+}
+
+func encodeFLACFile(in, out string) error {
+	content, err := os.ReadFile(in)
+	if err != nil {
+		return fmt.Errorf("failed to read audio file: %w", err)
+	}
+	reader := bytes.NewReader(content)
+	format, _, err := readWavHeader(reader)
+	if err != nil {
+		return err
+	}
+	pcm := content[len(content)-reader.Len():]
+
+	if format.BitDepth == 32 {
+		target := format
+		target.BitDepth = 24
+		pcm = newConverter(format, target).Convert(pcm)
+		format = target
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf("failed to create FLAC file: %w", err)
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := encodeFLAC(w, pcm, format); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write FLAC file: %w", err)
+	}
+	return nil
+}
+
+// runEncoder runs the first of the given commands whose tool is installed.
+func runEncoder(out string, commands ...[]string) error {
+	var tried []string
+	for _, args := range commands {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			tried = append(tried, args[0])
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			os.Remove(out)
+			return fmt.Errorf("%s failed: %w", args[0], err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no encoder found (tried %s)", strings.Join(tried, ", "))
+}
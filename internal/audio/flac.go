@@ -0,0 +1,355 @@
+package audio
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// FLAC encoding uses fixed-size blocks of independently coded channels with
+// FIXED prediction (orders 0-4) and partitioned Rice coding of the residual.
+// That gets speech to roughly half the size of PCM without the complexity of
+// LPC analysis.
+const (
+	flacBlockSize          = 4096
+	flacMaxPartitionOrder  = 6
+	flacMaxRiceParam       = 14 // 4-bit parameters, 15 is the escape code
+	flacMaxRiceParam5Bit   = 30 // 5-bit parameters, 31 is the escape code
+	flacSubframeConstant   = 0x00
+	flacSubframeVerbatim   = 0x01
+	flacSubframeFixedOrder = 0x08
+)
+
+// encodeFLAC writes pcm in format f as a FLAC stream. 32-bit audio is not
+// supported by most decoders and has to be converted to 24-bit first.
+func encodeFLAC(w io.Writer, pcm []byte, f Format) error {
+	if f.BitDepth != 16 && f.BitDepth != 24 {
+		return fmt.Errorf("FLAC encoding supports 16 and 24-bit audio, got %d-bit", f.BitDepth)
+	}
+	pcm = pcm[:len(pcm)-len(pcm)%f.BlockAlign()]
+	totalFrames := len(pcm) / f.BlockAlign()
+
+	// Header: marker and STREAMINFO, the only metadata block
+	header := make([]byte, 0, 42)
+	header = append(header, "fLaC"...)
+	header = append(header, 0x80, 0, 0, 34) // last block, type 0, length 34
+	// Min and max block size. The last block may be shorter without counting towards
+	// the minimum, so only a stream of a single block has a smaller size. Sizes
+	// below 16 are invalid here.
+	streamBlockSize := flacBlockSize
+	if totalFrames < flacBlockSize {
+		streamBlockSize = max(totalFrames, 16)
+	}
+	header = binary.BigEndian.AppendUint16(header, uint16(streamBlockSize))
+	header = binary.BigEndian.AppendUint16(header, uint16(streamBlockSize))
+	header = append(header, 0, 0, 0, 0, 0, 0) // min/max frame size unknown
+	// 20 bits sample rate, 3 bits channels-1, 5 bits bps-1, 36 bits total samples
+	info := uint64(f.SampleRate)<<44 | uint64(f.Channels-1)<<41 | uint64(f.BitDepth-1)<<36 | uint64(totalFrames)
+	header = binary.BigEndian.AppendUint64(header, info)
+	sum := md5.Sum(pcm) // MD5 of the interleaved little endian samples, which is our PCM as is
+	header = append(header, sum[:]...)
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write FLAC header: %w", err)
+	}
+
+	sampleSize := f.BitDepth / 8
+	channel := make([]int64, flacBlockSize)
+	for frameNum, start := 0, 0; start < totalFrames; frameNum, start = frameNum+1, start+flacBlockSize {
+		blockSize := min(flacBlockSize, totalFrames-start)
+
+		bw := &bitWriter{}
+		writeFLACFrameHeader(bw, f, frameNum, blockSize)
+		for ch := 0; ch < f.Channels; ch++ {
+			for i := 0; i < blockSize; i++ {
+				off := (start+i)*f.BlockAlign() + ch*sampleSize
+				channel[i] = decodeInt(pcm[off:], f.BitDepth)
+			}
+			writeFLACSubframe(bw, channel[:blockSize], f.BitDepth)
+		}
+		bw.align()
+		frame := bw.bytes()
+		frame = binary.BigEndian.AppendUint16(frame, crc16(frame))
+		if _, err := w.Write(frame); err != nil {
+			return fmt.Errorf("failed to write FLAC frame: %w", err)
+		}
+	}
+	return nil
+}
+
+// decodeInt returns the signed integer sample at the start of b.
+func decodeInt(b []byte, bitDepth int) int64 {
+	if bitDepth == 24 {
+		return int64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8)
+	}
+	return int64(int16(binary.LittleEndian.Uint16(b)))
+}
+
+func writeFLACFrameHeader(bw *bitWriter, f Format, frameNum, blockSize int) {
+	bw.writeBits(0xFFF8, 16) // sync code, fixed block size
+	bw.writeBits(0x7, 4)     // block size: 16 bits at end of header
+	bw.writeBits(0x0, 4)     // sample rate: from STREAMINFO
+	bw.writeBits(uint64(f.Channels-1), 4)
+	if f.BitDepth == 24 {
+		bw.writeBits(0x6, 3)
+	} else {
+		bw.writeBits(0x4, 3)
+	}
+	bw.writeBits(0, 1)
+	for _, b := range appendUTF8(nil, uint64(frameNum)) {
+		bw.writeBits(uint64(b), 8)
+	}
+	bw.writeBits(uint64(blockSize-1), 16)
+	bw.writeBits(uint64(crc8(bw.bytes())), 8)
+}
+
+// writeFLACSubframe codes one channel of a block with the cheapest of the
+// constant, fixed prediction and verbatim subframe types.
+func writeFLACSubframe(bw *bitWriter, samples []int64, bps int) {
+	constant := true
+	for _, s := range samples[1:] {
+		if s != samples[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		bw.writeBits(flacSubframeConstant<<1, 8)
+		bw.writeSigned(samples[0], uint(bps))
+		return
+	}
+
+	bestOrder, bestBits := -1, uint64(len(samples)*bps)
+	var bestResidual []int64
+	var bestParams []int
+	bestPartitionOrder := 0
+	for order := 0; order <= 4 && order < len(samples); order++ {
+		residual := fixedResidual(samples, order)
+		partitionOrder, params, cost := riceParameters(residual, len(samples), order)
+		cost += uint64(order * bps)
+		if cost < bestBits {
+			bestOrder, bestBits = order, cost
+			bestResidual, bestParams, bestPartitionOrder = residual, params, partitionOrder
+		}
+	}
+
+	if bestOrder < 0 {
+		bw.writeBits(flacSubframeVerbatim<<1, 8)
+		for _, s := range samples {
+			bw.writeSigned(s, uint(bps))
+		}
+		return
+	}
+
+	bw.writeBits(uint64(flacSubframeFixedOrder|bestOrder)<<1, 8)
+	for _, s := range samples[:bestOrder] {
+		bw.writeSigned(s, uint(bps))
+	}
+	writeResidual(bw, bestResidual, bestParams, bestPartitionOrder, len(samples), bestOrder)
+}
+
+// fixedResidual returns the residual of the FLAC fixed predictor of the given order.
+func fixedResidual(s []int64, order int) []int64 {
+	residual := make([]int64, 0, len(s)-order)
+	for i := order; i < len(s); i++ {
+		var r int64
+		switch order {
+		case 0:
+			r = s[i]
+		case 1:
+			r = s[i] - s[i-1]
+		case 2:
+			r = s[i] - 2*s[i-1] + s[i-2]
+		case 3:
+			r = s[i] - 3*s[i-1] + 3*s[i-2] - s[i-3]
+		case 4:
+			r = s[i] - 4*s[i-1] + 6*s[i-2] - 4*s[i-3] + s[i-4]
+		}
+		residual = append(residual, r)
+	}
+	return residual
+}
+
+// riceParameters picks the partition order and per-partition Rice parameters
+// that minimize the coded size of residual, which is returned in bits.
+func riceParameters(residual []int64, blockSize, predictorOrder int) (int, []int, uint64) {
+	bestOrder, bestCost := 0, ^uint64(0)
+	var bestParams []int
+	for order := 0; order <= flacMaxPartitionOrder; order++ {
+		partitions := 1 << order
+		if blockSize%partitions != 0 || blockSize/partitions <= predictorOrder {
+			break
+		}
+		params := make([]int, partitions)
+		cost := uint64(6) // coding method and partition order
+		start := 0
+		for p := range params {
+			end := (p+1)*(blockSize/partitions) - predictorOrder
+			var size uint64
+			params[p], size = bestRiceParam(residual[start:end])
+			cost += size
+			start = end
+		}
+		if cost < bestCost {
+			bestOrder, bestCost, bestParams = order, cost, params
+		}
+	}
+	return bestOrder, bestParams, bestCost
+}
+
+// bestRiceParam returns the Rice parameter for a partition and its coded size in bits,
+// including the 5-bit parameter field.
+func bestRiceParam(residual []int64) (int, uint64) {
+	var sum uint64
+	for _, r := range residual {
+		sum += zigzag(r)
+	}
+	n := uint64(len(residual))
+	if n == 0 {
+		return 0, 5
+	}
+	// The optimum is close to log2 of the mean, so only its neighbours need checking
+	guess := 0
+	if mean := sum / n; mean > 0 {
+		guess = bits.Len64(mean) - 1
+	}
+	best, bestCost := 0, ^uint64(0)
+	for k := max(guess-1, 0); k <= min(guess+1, flacMaxRiceParam5Bit); k++ {
+		var cost uint64
+		for _, r := range residual {
+			cost += zigzag(r)>>uint(k) + 1 + uint64(k)
+		}
+		if cost < bestCost {
+			best, bestCost = k, cost
+		}
+	}
+	return best, bestCost + 5
+}
+
+func writeResidual(bw *bitWriter, residual []int64, params []int, partitionOrder, blockSize, predictorOrder int) {
+	// Parameters never reach the escape codes, so partitions are always Rice coded
+	paramBits := uint(4)
+	for _, k := range params {
+		if k > flacMaxRiceParam {
+			paramBits = 5
+			break
+		}
+	}
+
+	if paramBits == 4 {
+		bw.writeBits(0, 2)
+	} else {
+		bw.writeBits(1, 2)
+	}
+	bw.writeBits(uint64(partitionOrder), 4)
+
+	partitionSize := blockSize >> partitionOrder
+	start := 0
+	for p, k := range params {
+		end := (p+1)*partitionSize - predictorOrder
+		bw.writeBits(uint64(k), paramBits)
+		for _, r := range residual[start:end] {
+			u := zigzag(r)
+			bw.writeUnary(u >> uint(k))
+			bw.writeBits(u&(1<<uint(k)-1), uint(k))
+		}
+		start = end
+	}
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+// appendUTF8 appends v in the extended UTF-8 coding FLAC uses for frame numbers.
+func appendUTF8(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+	n := 1 // continuation bytes
+	for v >= 1<<uint(5*n+6) {
+		n++
+	}
+	b = append(b, byte(0xFF<<(7-n))|byte(v>>(6*uint(n))))
+	for i := n - 1; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(6*uint(i)))&0x3F)
+	}
+	return b
+}
+
+func crc8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// bitWriter packs values MSB first.
+type bitWriter struct {
+	buf   []byte
+	cur   byte
+	nbits uint // bits used in cur
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for n > 0 {
+		take := min(n, 8-w.nbits)
+		w.cur = w.cur<<take | byte(v>>(n-take))&(1<<take-1)
+		w.nbits += take
+		n -= take
+		if w.nbits == 8 {
+			w.buf = append(w.buf, w.cur)
+			w.cur, w.nbits = 0, 0
+		}
+	}
+}
+
+// writeSigned writes v as an n-bit two's complement number.
+func (w *bitWriter) writeSigned(v int64, n uint) {
+	w.writeBits(uint64(v)&(1<<n-1), n)
+}
+
+// writeUnary writes q zero bits followed by a one bit.
+func (w *bitWriter) writeUnary(q uint64) {
+	for q >= 32 {
+		w.writeBits(0, 32)
+		q -= 32
+	}
+	w.writeBits(1, uint(q)+1)
+}
+
+// align pads with zero bits up to the next byte boundary.
+func (w *bitWriter) align() {
+	if w.nbits > 0 {
+		w.writeBits(0, 8-w.nbits)
+	}
+}
+
+// bytes returns the completed bytes written so far.
+func (w *bitWriter) bytes() []byte {
+	return w.buf
+}
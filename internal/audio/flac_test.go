@@ -0,0 +1,33 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestFLACStreamInfoBlockSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		want     uint16
+	}{
+		{"single short block", 100 * time.Millisecond, 1600},
+		{"shorter last block", time.Second, flacBlockSize},
+		{"tiny", 0, 16},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := encodeFLAC(&b, tone(DefaultFormat, 440, tt.duration), DefaultFormat); err != nil {
+				t.Fatal(err)
+			}
+			header := b.Bytes()
+			minSize, maxSize := binary.BigEndian.Uint16(header[8:10]), binary.BigEndian.Uint16(header[10:12])
+			if minSize != tt.want || maxSize != tt.want {
+				t.Errorf("block size min %d, max %d, want %d", minSize, maxSize, tt.want)
+			}
+		})
+	}
+}
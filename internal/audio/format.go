@@ -0,0 +1,341 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	wavHeaderSize = 44
+	// streamDataSize is the data size written to the header of a WAV stream whose
+	// length is not known up front. Decoders then read until the end of the stream.
+	streamDataSize = 0xFFFFFFFF
+)
+
+// Format describes interleaved little endian integer PCM.
+type Format struct {
+	SampleRate int
+	Channels   int
+	BitDepth   int // 16, 24 or 32
+}
+
+// DefaultFormat is what Whisper expects and what wkey records unless configured otherwise.
+var DefaultFormat = Format{SampleRate: 16000, Channels: 1, BitDepth: 16}
+
+func (f Format) String() string {
+	return fmt.Sprintf("%dHz/%dch/%dbit", f.SampleRate, f.Channels, f.BitDepth)
+}
+
+// Validate reports whether f can be recorded and converted.
+func (f Format) Validate() error {
+	if f.SampleRate < 8000 || f.SampleRate > 192000 {
+		return fmt.Errorf("unsupported sample rate %d", f.SampleRate)
+	}
+	if f.Channels < 1 || f.Channels > 8 {
+		return fmt.Errorf("unsupported channel count %d", f.Channels)
+	}
+	if f.BitDepth != 16 && f.BitDepth != 24 && f.BitDepth != 32 {
+		return fmt.Errorf("unsupported bit depth %d (use 16, 24 or 32)", f.BitDepth)
+	}
+	return nil
+}
+
+// BlockAlign returns the size of one frame (a sample for every channel) in bytes.
+func (f Format) BlockAlign() int {
+	return f.Channels * f.BitDepth / 8
+}
+
+// BytesPerSec returns the data rate of f.
+func (f Format) BytesPerSec() int {
+	return f.SampleRate * f.BlockAlign()
+}
+
+// Bytes returns the size of d of audio, rounded down to whole frames.
+func (f Format) Bytes(d time.Duration) int {
+	frames := int(d.Seconds() * float64(f.SampleRate))
+	return frames * f.BlockAlign()
+}
+
+// Duration returns the length of n bytes of audio.
+func (f Format) Duration(n int64) time.Duration {
+	return time.Duration(n) * time.Second / time.Duration(f.BytesPerSec())
+}
+
+func writeWavHeader(w io.Writer, f Format, dataSize uint32) {
+	// Standard 44-byte WAV header
+	riffSize := dataSize + 36
+	if dataSize > streamDataSize-36 {
+		riffSize = streamDataSize
+	}
+	binary.Write(w, binary.BigEndian, []byte("RIFF"))
+	binary.Write(w, binary.LittleEndian, riffSize)
+	binary.Write(w, binary.BigEndian, []byte("WAVE"))
+	binary.Write(w, binary.BigEndian, []byte("fmt "))
+	binary.Write(w, binary.LittleEndian, uint32(16))
+	binary.Write(w, binary.LittleEndian, uint16(1))               // PCM
+	binary.Write(w, binary.LittleEndian, uint16(f.Channels))      // Channels
+	binary.Write(w, binary.LittleEndian, uint32(f.SampleRate))    // Sample Rate
+	binary.Write(w, binary.LittleEndian, uint32(f.BytesPerSec())) // Byte Rate
+	binary.Write(w, binary.LittleEndian, uint16(f.BlockAlign()))  // Block Align
+	binary.Write(w, binary.LittleEndian, uint16(f.BitDepth))      // Bits Per Sample
+	binary.Write(w, binary.BigEndian, []byte("data"))
+	binary.Write(w, binary.LittleEndian, dataSize)
+}
+
+// pcmSubformatGUID is KSDATAFORMAT_SUBTYPE_PCM, the subformat of an extensible
+// WAV file holding integer PCM. Other subformats share its last 14 bytes and
+// carry their format tag in the first two.
+var pcmSubformatGUID = []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// readWavHeader parses the RIFF header of a PCM WAV file and leaves r positioned
+// at the start of the audio data. It returns the format and the size of the data
+// chunk as stated in the header.
+func readWavHeader(r io.Reader) (Format, uint32, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return Format{}, 0, fmt.Errorf("failed to read WAV header: %w", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return Format{}, 0, fmt.Errorf("not a WAV file")
+	}
+
+	var format Format
+	haveFmt := false
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return Format{}, 0, fmt.Errorf("no data chunk in WAV file: %w", err)
+		}
+		size := binary.LittleEndian.Uint32(chunk[4:8])
+
+		switch string(chunk[0:4]) {
+		case "fmt ":
+			body := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, body); err != nil || size < 16 {
+				return Format{}, 0, fmt.Errorf("invalid fmt chunk in WAV file")
+			}
+			// 1 is PCM, 0xFFFE is WAVE_FORMAT_EXTENSIBLE whose subformat must be PCM too
+			tag := binary.LittleEndian.Uint16(body[0:2])
+			if tag == 0xFFFE {
+				if size < 40 || !bytes.Equal(body[26:40], pcmSubformatGUID[2:]) {
+					return Format{}, 0, fmt.Errorf("unsupported WAV encoding, only PCM is supported")
+				}
+				tag = binary.LittleEndian.Uint16(body[24:26])
+			}
+			if tag != 1 {
+				return Format{}, 0, fmt.Errorf("unsupported WAV encoding %#x, only PCM is supported", tag)
+			}
+			format = Format{
+				Channels:   int(binary.LittleEndian.Uint16(body[2:4])),
+				SampleRate: int(binary.LittleEndian.Uint32(body[4:8])),
+				BitDepth:   int(binary.LittleEndian.Uint16(body[14:16])),
+			}
+			haveFmt = true
+		case "data":
+			if !haveFmt {
+				return Format{}, 0, fmt.Errorf("WAV data chunk before fmt chunk")
+			}
+			if err := format.Validate(); err != nil {
+				return Format{}, 0, err
+			}
+			return format, size, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return Format{}, 0, fmt.Errorf("failed to read WAV file: %w", err)
+			}
+		}
+	}
+}
+
+// decodeSample returns the sample at the start of b, normalized to [-1, 1).
+func decodeSample(b []byte, bitDepth int) float64 {
+	switch bitDepth {
+	case 24:
+		v := int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24) >> 8
+		return float64(v) / (1 << 23)
+	case 32:
+		return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31)
+	default:
+		return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15)
+	}
+}
+
+// appendSample encodes v, clamped to [-1, 1), and appends it to b.
+func appendSample(b []byte, v float64, bitDepth int) []byte {
+	v = math.Max(-1, math.Min(v, 1))
+	switch bitDepth {
+	case 24:
+		s := int32(math.Min(v*(1<<23), (1<<23)-1))
+		return append(b, byte(s), byte(s>>8), byte(s>>16))
+	case 32:
+		s := int32(math.Min(v*(1<<31), (1<<31)-1))
+		return binary.LittleEndian.AppendUint32(b, uint32(s))
+	default:
+		s := int16(math.Min(v*(1<<15), (1<<15)-1))
+		return binary.LittleEndian.AppendUint16(b, uint16(s))
+	}
+}
+
+// windowRMS returns the normalized RMS level (0.0-1.0) of PCM in format f, over all channels.
+func windowRMS(pcm []byte, f Format) float64 {
+	sampleSize := f.BitDepth / 8
+	numSamples := len(pcm) / sampleSize
+	if numSamples == 0 {
+		return 0
+	}
+	var sumSquares float64
+	for i := 0; i < numSamples; i++ {
+		normalized := decodeSample(pcm[i*sampleSize:], f.BitDepth)
+		sumSquares += normalized * normalized
+	}
+	return math.Sqrt(sumSquares / float64(numSamples))
+}
+
+// converter changes the channel count, sample rate and bit depth of a PCM stream.
+// Channels are downmixed by averaging or duplicated from mono, and the sample
+// rate is changed by linear interpolation, which is plenty for speech. When
+// downsampling, a low-pass filter first removes what the output rate cannot
+// represent, which would otherwise alias into the speech band.
+type converter struct {
+	in, out Format
+	step    float64   // input frames per output frame
+	pos     float64   // position of the next output frame, relative to prev
+	prev    []float64 // last input frame of the previous chunk, nil at the start
+	filter  *lowPass  // nil unless downsampling
+}
+
+func newConverter(in, out Format) *converter {
+	c := &converter{
+		in:   in,
+		out:  out,
+		step: float64(in.SampleRate) / float64(out.SampleRate),
+	}
+	if c.step > 1 {
+		// Cut off a little below the output Nyquist frequency, so that the
+		// transition band is mostly attenuated before it folds back
+		c.filter = newLowPass(0.45/c.step, 32*int(math.Ceil(c.step))+1, out.Channels)
+	}
+	return c
+}
+
+// Convert converts whole frames of input PCM. State is kept across calls, so
+// consecutive chunks of a stream are resampled seamlessly.
+func (c *converter) Convert(pcm []byte) []byte {
+	// Decode and remap channels
+	decoded := make([][]float64, 0, len(pcm)/c.in.BlockAlign())
+	sampleSize := c.in.BitDepth / 8
+	for off := 0; off+c.in.BlockAlign() <= len(pcm); off += c.in.BlockAlign() {
+		in := make([]float64, c.in.Channels)
+		for ch := range in {
+			in[ch] = decodeSample(pcm[off+ch*sampleSize:], c.in.BitDepth)
+		}
+		decoded = append(decoded, c.remap(in))
+	}
+	if c.filter != nil {
+		decoded = c.filter.Process(decoded)
+	}
+
+	frames := make([][]float64, 0, len(decoded)+1)
+	if c.prev != nil {
+		frames = append(frames, c.prev)
+	}
+	frames = append(frames, decoded...)
+	if len(frames) == 0 {
+		return nil
+	}
+
+	// Resample and encode
+	var out []byte
+	last := float64(len(frames) - 1)
+	for c.pos <= last {
+		i := int(c.pos)
+		frac := c.pos - float64(i)
+		for ch := 0; ch < c.out.Channels; ch++ {
+			v := frames[i][ch]
+			if frac > 0 && i+1 < len(frames) {
+				v += (frames[i+1][ch] - v) * frac
+			}
+			out = appendSample(out, v, c.out.BitDepth)
+		}
+		c.pos += c.step
+	}
+	// Keep the last frame to interpolate across the chunk boundary
+	c.pos -= last
+	c.prev = frames[len(frames)-1]
+	return out
+}
+
+// remap converts one frame to the output channel count.
+func (c *converter) remap(in []float64) []float64 {
+	if len(in) == c.out.Channels {
+		return in
+	}
+	out := make([]float64, c.out.Channels)
+	if c.out.Channels == 1 {
+		for _, v := range in {
+			out[0] += v
+		}
+		out[0] /= float64(len(in))
+		return out
+	}
+	for ch := range out {
+		out[ch] = in[ch%len(in)]
+	}
+	return out
+}
+
+// lowPass is a windowed-sinc FIR low-pass filter for a stream of frames.
+type lowPass struct {
+	taps []float64
+	hist [][]float64 // the last len(taps)-1 input frames
+}
+
+// newLowPass creates a filter with n taps passing frequencies below cutoff,
+// given as a fraction of the sample rate.
+func newLowPass(cutoff float64, n, channels int) *lowPass {
+	taps := make([]float64, n)
+	sum := 0.0
+	for k := range taps {
+		x := float64(k) - float64(n-1)/2
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(2*math.Pi*cutoff*x) / (2 * math.Pi * cutoff * x)
+		}
+		// Blackman window
+		w := 0.42 - 0.5*math.Cos(2*math.Pi*float64(k)/float64(n-1)) + 0.08*math.Cos(4*math.Pi*float64(k)/float64(n-1))
+		taps[k] = sinc * w
+		sum += taps[k]
+	}
+	// Unity gain for DC
+	for k := range taps {
+		taps[k] /= sum
+	}
+
+	hist := make([][]float64, n-1)
+	for i := range hist {
+		hist[i] = make([]float64, channels)
+	}
+	return &lowPass{taps: taps, hist: hist}
+}
+
+// Process filters frames, continuing from the frames of the previous call. The
+// output lags the input by half the filter length.
+func (f *lowPass) Process(frames [][]float64) [][]float64 {
+	buf := append(f.hist, frames...)
+	out := make([][]float64, len(frames))
+	for i := range out {
+		v := make([]float64, len(frames[i]))
+		for k, tap := range f.taps {
+			for ch, s := range buf[i+k] {
+				v[ch] += tap * s
+			}
+		}
+		out[i] = v
+	}
+	f.hist = append([][]float64(nil), buf[len(buf)-len(f.hist):]...)
+	return out
+}
@@ -0,0 +1,54 @@
+package audio
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConverterDownsampling(t *testing.T) {
+	in := Format{SampleRate: 48000, Channels: 1, BitDepth: 16}
+	tests := []struct {
+		freq    float64
+		minGain float64
+		maxGain float64
+	}{
+		{1000, 0.95, 1.05}, // speech passes
+		{12000, 0, 0.01},   // would alias to 4 kHz
+		{9000, 0, 0.1},     // would alias to 7 kHz
+	}
+	for _, tt := range tests {
+		wav := tone(in, tt.freq, time.Second)
+
+		// Convert in chunks of 50ms, as the recorder does
+		c := newConverter(in, DefaultFormat)
+		var out []byte
+		chunk := in.Bytes(50 * time.Millisecond)
+		for off := 0; off < len(wav); off += chunk {
+			out = append(out, c.Convert(wav[off:min(off+chunk, len(wav))])...)
+		}
+
+		if want := DefaultFormat.Bytes(time.Second); len(out) < want-16 || len(out) > want+16 {
+			t.Errorf("%v Hz: converted %d bytes, want about %d", tt.freq, len(out), want)
+		}
+		// Skip the filter's lag at the start
+		skip := DefaultFormat.Bytes(10 * time.Millisecond)
+		gain := windowRMS(out[skip:], DefaultFormat) / windowRMS(wav, in)
+		if gain < tt.minGain || gain > tt.maxGain {
+			t.Errorf("%v Hz: gain %.3f, want between %v and %v", tt.freq, gain, tt.minGain, tt.maxGain)
+		}
+	}
+}
+
+// tone returns d of a sine at freq as PCM in format.
+func tone(format Format, freq float64, d time.Duration) []byte {
+	var pcm []byte
+	frames := format.Bytes(d) / format.BlockAlign()
+	for i := 0; i < frames; i++ {
+		v := 0.5 * math.Sin(2*math.Pi*freq*float64(i)/float64(format.SampleRate))
+		for c := 0; c < format.Channels; c++ {
+			pcm = appendSample(pcm, v, format.BitDepth)
+		}
+	}
+	return pcm
+}
@@ -0,0 +1,123 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Input warnings reported while recording.
+const (
+	WarningMuted    = "Microphone appears muted"
+	WarningClipping = "Input clipping"
+)
+
+const (
+	mutedAfter      = 1 * time.Second // digital silence needed to report a muted microphone
+	clipLevel       = 0.99            // samples at or above this count as clipped
+	clipRatio       = 0.01            // clipped fraction of a chunk that reports clipping
+	clipClearAfter  = 2 * time.Second // clipping warning stays this long after the last clip
+	silentPeakLevel = 0.001           // -60 dBFS, below any real microphone's noise floor
+)
+
+// InputStats summarizes the signal of a recording.
+type InputStats struct {
+	Peak           float64       // highest absolute sample level (0.0-1.0)
+	Clipped        float64       // fraction of samples at full scale
+	LongestZeroRun time.Duration // longest stretch of exact digital silence
+}
+
+// Silent reports whether the recording contains no signal at all, as produced
+// by a muted or disconnected microphone.
+func (s InputStats) Silent() bool {
+	return s.Peak < silentPeakLevel
+}
+
+func (s InputStats) String() string {
+	return fmt.Sprintf("peak %.3f, clipped %.2f%%, longest zero run %v", s.Peak, s.Clipped*100, s.LongestZeroRun.Round(time.Millisecond))
+}
+
+// inputMonitor tracks peak level, zero runs and clipping of a recording and
+// decides which warning, if any, applies to the input right now.
+type inputMonitor struct {
+	format         Format
+	peak           float64
+	samples        int
+	clipped        int
+	frames         int // frames processed
+	zeroRun        int // frames of digital silence up to now
+	longestZeroRun int
+	lastClip       int // frame of the last clipping chunk, -1 if none
+	warning        string
+}
+
+func newInputMonitor(f Format) *inputMonitor {
+	return &inputMonitor{format: f, lastClip: -1}
+}
+
+// Process analyses a chunk of whole frames. It returns the current warning
+// ("" if none) and whether it changed with this chunk.
+func (m *inputMonitor) Process(pcm []byte) (string, bool) {
+	sampleSize := m.format.BitDepth / 8
+	block := m.format.BlockAlign()
+	chunkSamples, chunkClipped := 0, 0
+	for off := 0; off+block <= len(pcm); off += block {
+		zero := true
+		for ch := 0; ch < m.format.Channels; ch++ {
+			level := math.Abs(decodeSample(pcm[off+ch*sampleSize:], m.format.BitDepth))
+			if level != 0 {
+				zero = false
+			}
+			if level >= clipLevel {
+				chunkClipped++
+			}
+			m.peak = math.Max(m.peak, level)
+			chunkSamples++
+		}
+		if zero {
+			m.zeroRun++
+			m.longestZeroRun = max(m.longestZeroRun, m.zeroRun)
+		} else {
+			m.zeroRun = 0
+		}
+		m.frames++
+	}
+	m.samples += chunkSamples
+	m.clipped += chunkClipped
+	if chunkSamples > 0 && float64(chunkClipped)/float64(chunkSamples) > clipRatio {
+		m.lastClip = m.frames
+	}
+
+	warning := ""
+	switch {
+	case m.duration(m.zeroRun) >= mutedAfter:
+		warning = WarningMuted
+	case m.lastClip >= 0 && m.duration(m.frames-m.lastClip) < clipClearAfter:
+		warning = WarningClipping
+	}
+	changed := warning != m.warning
+	m.warning = warning
+	return warning, changed
+}
+
+// interrupt marks a gap in the input, such as a pause. Zero runs and clipping
+// do not carry over it, and the current warning is cleared without being reported,
+// so that it is reported again if it persists.
+func (m *inputMonitor) interrupt() {
+	m.zeroRun = 0
+	m.lastClip = -1
+	m.warning = ""
+}
+
+func (m *inputMonitor) duration(frames int) time.Duration {
+	return time.Duration(frames) * time.Second / time.Duration(m.format.SampleRate)
+}
+
+// Stats returns the statistics of everything processed so far.
+func (m *inputMonitor) Stats() InputStats {
+	stats := InputStats{Peak: m.peak, LongestZeroRun: m.duration(m.longestZeroRun)}
+	if m.samples > 0 {
+		stats.Clipped = float64(m.clipped) / float64(m.samples)
+	}
+	return stats
+}
@@ -1,114 +1,172 @@
 package audio
 
 import (
-	"encoding/binary"
+	"bytes"
 	"fmt"
 	"io"
-	"math"
 	"os"
-	"os/exec"
 	"sync"
 	"time"
 )
 
-// Recorder handles audio recording using external tools (pw-record)
+// Recorder handles audio recording using a capture Backend (pw-record by default)
 type Recorder struct {
-	cmd        *exec.Cmd
-	wg         sync.WaitGroup
-	outFile    *os.File
-	totalBytes uint32
-}
+	backend     Backend
+	format      Format // format of the recorded WAV files
+	capture     Capture
+	wg          sync.WaitGroup
+	captureDone chan struct{}  // closed when the capture output ends
+	persistent  bool           // capture outlives individual recordings (see Open)
+	notifying   sync.WaitGroup // callbacks of the current recording still running
 
-// NewRecorder creates a new Recorder instance
-func NewRecorder() *Recorder {
-	return &Recorder{}
+	mu            sync.Mutex // guards everything below, shared with the capture goroutine
+	recording     bool
+	paused        bool          // recording, but captured audio is discarded
+	outFile       *os.File      // nil when only streaming
+	stream        *streamWriter // nil when only writing a file
+	totalBytes    uint32
+	preRollBytes  uint32      // part of totalBytes taken from the pre-roll buffer
+	preRoll       *ringBuffer // audio captured while idle, nil if disabled
+	onLevel       func(float64)
+	spectrum      *spectrumAnalyzer
+	onSpectrum    func([]float64)
+	monitor       *inputMonitor // statistics of the current or last recording
+	onWarning     func(string)
+	vad           *VAD
+	onEndOfSpeech func()
+	segmentOpts   SegmentOptions
+	segments      *segmenter // boundaries of the current recording, nil if disabled
+	onSegment     func(int64)
 }
 
-// Start begins recording to the specified filename.
-// It uses pw-record with 16kHz, mono, 16-bit PCM settings.
-// onLevel is called with normalized audio level (0.0-1.0) periodically.
-func (r *Recorder) Start(filename string, onLevel func(float64)) error {
-	fmt.Printf("[Recorder] Starting pw-record to %s\n", filename)
-	// Check if pw-record is available
-	_, err := exec.LookPath("pw-record")
-	if err != nil {
-		return fmt.Errorf("pw-record not found: %w", err)
+// NewRecorder creates a new Recorder capturing from backend and writing WAV
+// files in format. A nil backend auto-detects the capture tool on each start,
+// and a zero format means DefaultFormat.
+func NewRecorder(backend Backend, format Format) *Recorder {
+	if format == (Format{}) {
+		format = DefaultFormat
+	}
+	if backend == nil {
+		backend = autoBackend{opts: BackendOptions{Format: format}}
 	}
+	return &Recorder{backend: backend, format: format}
+}
+
+// SetVAD enables voice activity detection for the next recording.
+// onEndOfSpeech is called once from the recording goroutine when the detector
+// reports that the speaker has stopped talking. Passing a nil VAD disables detection.
+func (r *Recorder) SetVAD(vad *VAD, onEndOfSpeech func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vad = vad
+	r.onEndOfSpeech = onEndOfSpeech
+}
 
-	// pw-record --format=s16 --rate=16000 --channels=1 -
-	// We output to stdout (-) to capture data
-	r.cmd = exec.Command("pw-record", "--format=s16", "--rate=16000", "--channels=1", "-")
+// SetSegments makes the recorder split the next recordings into segments at
+// pauses in speech. onSegment is called from the recording goroutine whenever a
+// segment has been completely written, with the end of the segment as an offset
+// into the audio data of the file; see ExtractSegment. A nil onSegment disables it.
+func (r *Recorder) SetSegments(opts SegmentOptions, onSegment func(end int64)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.segmentOpts = opts
+	r.onSegment = onSegment
+}
 
-	stdout, err := r.cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("failed to get stdout pipe: %w", err)
+// SetSpectrum makes the recorder compute the levels (0.0-1.0) of numBands
+// logarithmically spaced frequency bands for every chunk of audio recorded, and
+// pass them to onSpectrum from the recording goroutine. A nil onSpectrum disables it.
+func (r *Recorder) SetSpectrum(numBands int, onSpectrum func([]float64)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if onSpectrum == nil || numBands <= 0 {
+		r.spectrum, r.onSpectrum = nil, nil
+		return
 	}
+	r.spectrum = newSpectrumAnalyzer(r.format, numBands)
+	r.onSpectrum = onSpectrum
+}
 
-	r.cmd.Stderr = os.Stderr
+// SetOnWarning registers a function called from the recording goroutine when
+// the input warning changes: WarningMuted, WarningClipping, or "" once the
+// condition has cleared.
+func (r *Recorder) SetOnWarning(onWarning func(string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onWarning = onWarning
+}
 
-	r.outFile, err = os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+// SetPreRoll keeps the last d of audio captured while no recording is in progress
+// and prepends it to the next recording, so that the first syllable spoken right
+// as recording starts is not lost. It only has an effect together with Open.
+// A zero duration disables the pre-roll buffer.
+func (r *Recorder) SetPreRoll(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	size := r.format.Bytes(d)
+	if size <= 0 {
+		r.preRoll = nil
+		return
 	}
+	r.preRoll = newRingBuffer(size)
+}
 
-	// Write initial empty header (44 bytes)
-	header := make([]byte, 44)
-	if _, err := r.outFile.Write(header); err != nil {
-		r.outFile.Close()
-		return fmt.Errorf("failed to write initial header: %w", err)
-	}
-	r.totalBytes = 0
+// Open starts the capture process ahead of time, so that Start only has to attach
+// an output file and no audio is lost to process startup. Audio captured while no
+// recording is in progress is discarded. Close releases the microphone again.
+func (r *Recorder) Open() error {
+	r.persistent = true
+	return r.startCapture()
+}
+
+// Close stops a capture process started by Open.
+func (r *Recorder) Close() error {
+	r.persistent = false
+	return r.Stop()
+}
 
-	if err := r.cmd.Start(); err != nil {
-		r.outFile.Close()
+// startCapture starts the backend and the goroutine that consumes its output.
+func (r *Recorder) startCapture() error {
+	fmt.Printf("[Recorder] Starting capture (backend: %s)\n", r.backend.Name())
+	capture, err := r.backend.Start()
+	if err != nil {
 		return fmt.Errorf("failed to start recording: %w", err)
 	}
+	r.capture = capture
+
+	// Convert when the backend cannot deliver the requested format itself
+	captureFormat := capture.Format()
+	var conv *converter
+	if captureFormat != r.format {
+		fmt.Printf("[Recorder] Converting %v to %v\n", captureFormat, r.format)
+		conv = newConverter(captureFormat, r.format)
+	}
 
+	r.captureDone = make(chan struct{})
 	r.wg.Add(1)
 	// Process audio in background
 	go func() {
 		defer r.wg.Done()
+		defer close(r.captureDone)
 		fmt.Printf("[Recorder] Data processing goroutine started\n")
 
-		// 16kHz, 16-bit mono = 32000 bytes/sec
-		// Process chunks of ~50ms = 1600 bytes
-		buf := make([]byte, 1600)
-		
-		lastPrint := time.Now()
+		// Process chunks of ~50ms (1600 bytes at 16kHz, 16-bit mono)
+		block := captureFormat.BlockAlign()
+		buf := make([]byte, max(captureFormat.BytesPerSec()/20/block, 1)*block)
 
+		// Reads can end mid-frame, so carry partial frames over to the next chunk
+		var pending []byte
 		for {
-			n, err := stdout.Read(buf)
+			n, err := capture.Read(buf)
 			if n > 0 {
-				// Write to file
-				if _, wErr := r.outFile.Write(buf[:n]); wErr != nil {
-					fmt.Fprintf(os.Stderr, "[Recorder] Error writing to file: %v\n", wErr)
-				}
-				r.totalBytes += uint32(n)
-				
-				if time.Since(lastPrint) > 2*time.Second {
-					fmt.Printf("[Recorder] Total bytes written: %d\n", r.totalBytes)
-					lastPrint = time.Now()
-				}
-
-				// Calculate RMS
-				if onLevel != nil {
-					var sumSquares float64
-					numSamples := n / 2
-					for i := 0; i < numSamples; i++ {
-						// Little endian 16-bit
-						sample := int16(binary.LittleEndian.Uint16(buf[i*2 : i*2+2]))
-						normalized := float64(sample) / 32768.0
-						sumSquares += normalized * normalized
-					}
-					rms := math.Sqrt(sumSquares / float64(numSamples))
-
-					// Boost level slightly for better visual
-					displayLevel := rms * 5.0
-					if displayLevel > 1.0 {
-						displayLevel = 1.0
+				pending = append(pending, buf[:n]...)
+				if whole := len(pending) - len(pending)%block; whole > 0 {
+					chunk := pending[:whole]
+					if conv != nil {
+						chunk = conv.Convert(chunk)
 					}
-
-					onLevel(displayLevel)
+					r.process(chunk)
+					pending = append(pending[:0], pending[whole:]...)
 				}
 			}
 			if err != nil {
@@ -123,37 +181,302 @@ func (r *Recorder) Start(filename string, onLevel func(float64)) error {
 	return nil
 }
 
-// Stop stops the recording gracefully by sending SIGINT.
-func (r *Recorder) Stop() error {
-	if r.cmd == nil || r.cmd.Process == nil {
-		fmt.Printf("[Recorder] Stop called but no process running\n")
+// captureRunning reports whether the capture is still delivering audio.
+func (r *Recorder) captureRunning() bool {
+	if r.capture == nil {
+		return false
+	}
+	select {
+	case <-r.captureDone:
+		return false
+	default:
+		return true
+	}
+}
+
+// process handles one chunk of captured audio. The callbacks run once mu has
+// been released, so that a slow one does not hold up Duration or Pause. Stop
+// waits for them, so none arrives after the recording has ended.
+func (r *Recorder) process(chunk []byte) {
+	notify := r.record(chunk)
+	if notify == nil {
+		return
+	}
+	defer r.notifying.Done()
+	for _, f := range notify {
+		f()
+	}
+}
+
+// record adds chunk to the current recording and returns the callbacks it
+// triggers. If there are any, notifying has been incremented for them.
+func (r *Recorder) record(chunk []byte) []func() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Not recording: keep the most recent audio for the pre-roll, discard the rest
+	if !r.recording {
+		if r.preRoll != nil {
+			r.preRoll.Write(chunk)
+		}
+		return nil
+	}
+	if r.paused {
 		return nil
 	}
 
-	fmt.Printf("[Recorder] Sending SIGINT to pw-record (PID: %d)...\n", r.cmd.Process.Pid)
-	// Send SIGINT to allow pw-record to finalize the file header
-	if err := r.cmd.Process.Signal(os.Interrupt); err != nil {
-		// If process is already dead, ignore
-		return fmt.Errorf("failed to send interrupt signal: %w", err)
+	var notify []func()
+	prevBytes := r.totalBytes
+	r.write(chunk)
+
+	// Watch for a muted or overdriven microphone
+	if warning, changed := r.monitor.Process(chunk); changed {
+		if warning != "" {
+			fmt.Printf("[Recorder] Warning: %s\n", warning)
+		} else {
+			fmt.Printf("[Recorder] Input warning cleared\n")
+		}
+		if onWarning := r.onWarning; onWarning != nil {
+			notify = append(notify, func() { onWarning(warning) })
+		}
 	}
 
-	// Wait for the process to exit
-	fmt.Printf("[Recorder] Waiting for pw-record to exit...\n")
-	err := r.cmd.Wait()
+	bytesPerSec := uint32(r.format.BytesPerSec())
+	if prevBytes/(2*bytesPerSec) != r.totalBytes/(2*bytesPerSec) {
+		fmt.Printf("[Recorder] Total bytes written: %d\n", r.totalBytes)
+	}
 
-	// Wait for file writing to finish
-	fmt.Printf("[Recorder] Waiting for data processing goroutine to finish...\n")
-	r.wg.Wait()
+	// Calculate RMS
+	rms := windowRMS(chunk, r.format)
 
-	// Finalize WAV header
+	// Voice activity detection
+	if r.vad != nil {
+		chunkDuration := r.format.Duration(int64(len(chunk)))
+		if r.vad.Process(rms, chunkDuration) {
+			fmt.Printf("[Recorder] End of speech detected\n")
+			if r.onEndOfSpeech != nil {
+				notify = append(notify, r.onEndOfSpeech)
+			}
+		}
+	}
+
+	if r.segments != nil && r.segments.Process(rms, len(chunk), r.totalBytes) {
+		fmt.Printf("[Recorder] Segment ends at %v\n", r.format.Duration(int64(r.totalBytes)))
+		onSegment, end := r.onSegment, int64(r.totalBytes)
+		notify = append(notify, func() { onSegment(end) })
+	}
+
+	if onLevel := r.onLevel; onLevel != nil {
+		// Boost level slightly for better visual
+		displayLevel := rms * 5.0
+		if displayLevel > 1.0 {
+			displayLevel = 1.0
+		}
+
+		notify = append(notify, func() { onLevel(displayLevel) })
+	}
+
+	if onSpectrum := r.onSpectrum; onSpectrum != nil {
+		levels := r.spectrum.Process(chunk)
+		notify = append(notify, func() { onSpectrum(levels) })
+	}
+	if notify != nil {
+		// Added under mu while recording, so detach cannot miss it
+		r.notifying.Add(1)
+	}
+	return notify
+}
+
+// write sends captured audio to the outputs of the current recording. mu must be held.
+func (r *Recorder) write(p []byte) {
 	if r.outFile != nil {
-		fmt.Printf("[Recorder] Finalizing WAV header (total bytes: %d)...\n", r.totalBytes)
-		if _, sErr := r.outFile.Seek(0, 0); sErr == nil {
-			r.writeWavHeader(r.outFile, r.totalBytes)
+		if _, err := r.outFile.Write(p); err != nil {
+			fmt.Fprintf(os.Stderr, "[Recorder] Error writing to file: %v\n", err)
 		}
-		r.outFile.Close()
+	}
+	if r.stream != nil && !r.stream.Write(p) {
+		// Keep capturing rather than wait for the receiver, and keep writing the file if there is one
+		fmt.Fprintf(os.Stderr, "[Recorder] Stream is falling behind, dropping it\n")
+		r.stream.Abort()
+		r.stream = nil
+	}
+	r.totalBytes += uint32(len(p))
+}
+
+// Start begins recording to the specified filename.
+// The file is written in the format given to NewRecorder.
+// onLevel is called with normalized audio level (0.0-1.0) periodically.
+func (r *Recorder) Start(filename string, onLevel func(float64)) error {
+	return r.start(filename, nil, onLevel)
+}
+
+// StartStream begins recording into w as a WAV stream of unknown length, so that
+// it can be uploaded while recording. The audio is written to w from a separate
+// goroutine; if a write fails, the stream is dropped. If w falls more than about
+// 10s behind, it is dropped too, and closed with ErrStreamOverflow if it has a
+// CloseWithError method. Otherwise w is not closed; Stop returns once the audio
+// has been written to it.
+// If filename is not empty, the recording is also written there as a regular WAV file.
+func (r *Recorder) StartStream(w io.Writer, filename string, onLevel func(float64)) error {
+	return r.start(filename, w, onLevel)
+}
+
+func (r *Recorder) start(filename string, stream io.Writer, onLevel func(float64)) error {
+	var outFile *os.File
+	if filename != "" {
+		fmt.Printf("[Recorder] Recording to %s\n", filename)
+
+		var err error
+		outFile, err = os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+
+		// Write a provisional header of unknown length, completed by Stop.
+		// It lets ExtractSegment read the file while it is being recorded.
+		var header bytes.Buffer
+		writeWavHeader(&header, r.format, streamDataSize)
+		if _, err := outFile.Write(header.Bytes()); err != nil {
+			outFile.Close()
+			return fmt.Errorf("failed to write initial header: %w", err)
+		}
+	}
+
+	var streamOut *streamWriter
+	if stream != nil {
+		fmt.Printf("[Recorder] Streaming recording\n")
+		var header bytes.Buffer
+		writeWavHeader(&header, r.format, streamDataSize)
+		streamOut = newStreamWriter(stream)
+		streamOut.Write(header.Bytes())
+	}
+
+	r.mu.Lock()
+	r.totalBytes = 0
+	r.preRollBytes = 0
+	r.outFile = outFile
+	r.stream = streamOut
+	r.recording = true
+	r.paused = false
+	r.monitor = newInputMonitor(r.format)
+	r.segments = nil
+	if r.onSegment != nil && outFile != nil {
+		r.segments = newSegmenter(r.segmentOpts, r.format)
+	}
+	if r.preRoll != nil && r.persistent && r.captureRunning() {
+		preRoll := r.preRoll.Bytes()
+		r.preRoll.Reset()
+		r.write(preRoll)
+		fmt.Printf("[Recorder] Prepended %d bytes of pre-roll\n", len(preRoll))
+		r.preRollBytes = r.totalBytes
+	}
+	r.onLevel = onLevel
+	r.mu.Unlock()
+
+	// With Open the capture is already running, unless it died in the meantime
+	if r.persistent && r.captureRunning() {
+		return nil
+	}
+	if r.persistent && r.capture != nil {
+		fmt.Printf("[Recorder] Capture ended, restarting\n")
+		r.wg.Wait()
+		r.capture.Wait()
+	}
+	if err := r.startCapture(); err != nil {
+		r.mu.Lock()
 		r.outFile = nil
+		streamOut = r.stream
+		r.stream = nil
+		r.recording = false
+		r.mu.Unlock()
+		if outFile != nil {
+			outFile.Close()
+		}
+		if streamOut != nil {
+			streamOut.Close()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Pause stops adding captured audio to the current recording until Resume is
+// called. The capture keeps running, so resuming is instant. Paused time does not
+// count towards Duration.
+func (r *Recorder) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.recording || r.paused {
+		return
+	}
+	fmt.Printf("[Recorder] Paused after %v\n", r.format.Duration(int64(r.totalBytes-r.preRollBytes)))
+	r.paused = true
+	// The input is discontinuous across the pause, so its checks start afresh
+	r.monitor.interrupt()
+}
+
+// Resume continues a recording paused by Pause.
+func (r *Recorder) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.recording || !r.paused {
+		return
+	}
+	fmt.Printf("[Recorder] Resumed\n")
+	r.paused = false
+}
+
+// Stats returns the input statistics of the current or last recording.
+// Like Duration, it is only complete once Stop has returned.
+func (r *Recorder) Stats() InputStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.monitor == nil {
+		return InputStats{}
 	}
+	return r.monitor.Stats()
+}
+
+// Duration returns the length of the audio recorded since Start, not counting
+// pre-roll or pauses. While recording, it is the audio written so far; the
+// final length is only known once Stop has returned.
+func (r *Recorder) Duration() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.format.Duration(int64(r.totalBytes - r.preRollBytes))
+}
+
+// Stop ends the current recording and finalizes the WAV file.
+// Without Open, the capture is stopped gracefully and its remaining output drained;
+// with Open, it keeps running for the next recording.
+func (r *Recorder) Stop() error {
+	if r.persistent {
+		r.finalize()
+		fmt.Printf("[Recorder] Stop finished, capture kept open\n")
+		return nil
+	}
+
+	if r.capture == nil {
+		fmt.Printf("[Recorder] Stop called but no capture running\n")
+		r.finalize()
+		return nil
+	}
+
+	// Ask the backend to flush its remaining output and end the stream
+	if err := r.capture.Stop(); err != nil {
+		// If the capture has already ended, ignore
+		fmt.Printf("[Recorder] %v\n", err)
+	}
+
+	// Wait for file writing to finish before releasing the capture,
+	// which closes its output
+	fmt.Printf("[Recorder] Waiting for data processing goroutine to finish...\n")
+	r.wg.Wait()
+	err := r.capture.Wait()
+	r.capture = nil
+
+	r.finalize()
 	fmt.Printf("[Recorder] Stop finished\n")
 
 	if err != nil {
@@ -166,19 +489,39 @@ func (r *Recorder) Stop() error {
 	return nil
 }
 
-func (r *Recorder) writeWavHeader(w io.Writer, dataSize uint32) {
-	// Standard 44-byte WAV header
-	binary.Write(w, binary.BigEndian, []byte("RIFF"))
-	binary.Write(w, binary.LittleEndian, dataSize+36)
-	binary.Write(w, binary.BigEndian, []byte("WAVE"))
-	binary.Write(w, binary.BigEndian, []byte("fmt "))
-	binary.Write(w, binary.LittleEndian, uint32(16))
-	binary.Write(w, binary.LittleEndian, uint16(1))     // PCM
-	binary.Write(w, binary.LittleEndian, uint16(1))     // Mono
-	binary.Write(w, binary.LittleEndian, uint32(16000)) // Sample Rate
-	binary.Write(w, binary.LittleEndian, uint32(32000)) // Byte Rate
-	binary.Write(w, binary.LittleEndian, uint16(2))     // Block Align
-	binary.Write(w, binary.LittleEndian, uint16(16))    // Bits Per Sample
-	binary.Write(w, binary.BigEndian, []byte("data"))
-	binary.Write(w, binary.LittleEndian, dataSize)
+// finalize writes the WAV header of the current output file and detaches it.
+// It waits for the callbacks of the recording and the rest of the stream.
+func (r *Recorder) finalize() {
+	// Both are waited for without holding mu, so that a slow callback or receiver
+	// does not block the recorder; nothing is added to them once recording is false
+	stream := r.detach()
+	r.notifying.Wait()
+	if stream != nil {
+		stream.Close()
+	}
+}
+
+// detach ends the current recording and returns its stream, if any.
+func (r *Recorder) detach() *streamWriter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.recording {
+		fmt.Printf("[Recorder] Input stats: %v\n", r.monitor.Stats())
+	}
+	stream := r.stream
+	r.onLevel = nil
+	r.stream = nil
+	r.recording = false
+	r.paused = false
+	if r.outFile == nil {
+		return stream
+	}
+	fmt.Printf("[Recorder] Finalizing WAV header (total bytes: %d)...\n", r.totalBytes)
+	if _, sErr := r.outFile.Seek(0, 0); sErr == nil {
+		writeWavHeader(r.outFile, r.format, r.totalBytes)
+	}
+	r.outFile.Close()
+	r.outFile = nil
+	return stream
 }
@@ -0,0 +1,158 @@
+package audio
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// toneFixture returns d of a 440 Hz sine in format as a WAV file.
+func toneFixture(format Format, d time.Duration) []byte {
+	pcm := tone(format, 440, d)
+	var wav bytes.Buffer
+	writeWavHeader(&wav, format, uint32(len(pcm)))
+	wav.Write(pcm)
+	return wav.Bytes()
+}
+
+// waitRecorded waits until r has recorded d of audio.
+func waitRecorded(t *testing.T, r *Recorder, d time.Duration) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); r.Duration() < d; {
+		if time.Now().After(deadline) {
+			t.Fatalf("recorded %v of %v", r.Duration(), d)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRecorderFileFixture(t *testing.T) {
+	tests := []struct {
+		name  string
+		input Format
+	}{
+		{"recording format", DefaultFormat},
+		{"converted", Format{SampleRate: 48000, Channels: 2, BitDepth: 32}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			fixture := toneFixture(tt.input, time.Second)
+			input := filepath.Join(dir, "input.wav")
+			if err := os.WriteFile(input, fixture, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			backend, err := NewBackend(BackendFile, BackendOptions{File: input})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := NewRecorder(backend, DefaultFormat)
+			output := filepath.Join(dir, "output.wav")
+			if err := r.Start(output, nil); err != nil {
+				t.Fatal(err)
+			}
+			// The file is played back as fast as it can be read, but Stop would cut it short
+			waitRecorded(t, r, 990*time.Millisecond)
+			if err := r.Stop(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := r.Duration(); got < 990*time.Millisecond || got > time.Second {
+				t.Errorf("Duration() = %v, want 1s", got)
+			}
+			recorded, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			format, size, err := readWavHeader(bytes.NewReader(recorded))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != DefaultFormat {
+				t.Errorf("recorded format = %v, want %v", format, DefaultFormat)
+			}
+			if want := len(recorded) - 44; int(size) != want {
+				t.Errorf("data size in header = %d, want %d", size, want)
+			}
+			if tt.input == DefaultFormat && !bytes.Equal(recorded, fixture) {
+				t.Errorf("recording differs from the fixture")
+			}
+		})
+	}
+}
+
+func TestRecorderStopStdin(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = pr
+	t.Cleanup(func() {
+		pw.Close()
+		pr.Close()
+		os.Stdin = stdin
+	})
+
+	// Half a second of audio, with the writer still holding stdin open
+	fixture := toneFixture(DefaultFormat, 500*time.Millisecond)
+	writeWavHeader(pw, DefaultFormat, streamDataSize)
+	pw.Write(fixture[44:])
+
+	backend, err := NewBackend(BackendFile, BackendOptions{File: "-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRecorder(backend, DefaultFormat)
+	if err := r.Start(filepath.Join(t.TempDir(), "output.wav"), nil); err != nil {
+		t.Fatal(err)
+	}
+	waitRecorded(t, r, 500*time.Millisecond)
+
+	stopped := make(chan error)
+	go func() { stopped <- r.Stop() }()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Stop blocked on stdin")
+	}
+}
+
+func TestRecorderStopWaitsForCallbacks(t *testing.T) {
+	r := NewRecorder(nil, DefaultFormat)
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	r.persistent = true
+	r.recording = true
+	r.monitor = newInputMonitor(r.format)
+	r.onLevel = func(float64) {
+		close(entered)
+		<-release
+	}
+
+	go r.process(tone(DefaultFormat, 440, 50*time.Millisecond))
+	<-entered
+	stopped := make(chan struct{})
+	go func() {
+		r.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned while a callback was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return after the callback")
+	}
+}
@@ -0,0 +1,50 @@
+package audio
+
+// ringBuffer keeps the most recent bytes written to it, overwriting the oldest.
+type ringBuffer struct {
+	buf  []byte
+	pos  int  // next write position
+	full bool // buf has wrapped at least once
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, size)}
+}
+
+// Write appends p, discarding the oldest data once the buffer is full.
+func (r *ringBuffer) Write(p []byte) {
+	if len(r.buf) == 0 {
+		return
+	}
+	if len(p) >= len(r.buf) {
+		copy(r.buf, p[len(p)-len(r.buf):])
+		r.pos = 0
+		r.full = true
+		return
+	}
+	n := copy(r.buf[r.pos:], p)
+	if n < len(p) {
+		copy(r.buf, p[n:])
+		r.full = true
+	}
+	r.pos = (r.pos + len(p)) % len(r.buf)
+	if r.pos == 0 {
+		r.full = true
+	}
+}
+
+// Bytes returns the buffered data, oldest first.
+func (r *ringBuffer) Bytes() []byte {
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.pos]...)
+	}
+	out := make([]byte, 0, len(r.buf))
+	out = append(out, r.buf[r.pos:]...)
+	return append(out, r.buf[:r.pos]...)
+}
+
+// Reset discards all buffered data.
+func (r *ringBuffer) Reset() {
+	r.pos = 0
+	r.full = false
+}
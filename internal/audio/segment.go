@@ -0,0 +1,91 @@
+package audio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// SegmentOptions controls how a long recording is split into segments that can
+// be transcribed while recording continues.
+type SegmentOptions struct {
+	Threshold float64       // RMS level (0.0-1.0) below which audio counts as silence
+	Silence   time.Duration // pause that ends a segment
+	MinLength time.Duration // segments are not ended by a pause before this length
+	MaxLength time.Duration // segments are ended at this length even without a pause, no limit if not positive
+}
+
+// segmenter finds segment boundaries in a recording from the RMS of its chunks.
+type segmenter struct {
+	opts   SegmentOptions
+	format Format
+	start  uint32        // data offset of the current segment
+	quiet  time.Duration // duration of the current run of silent chunks
+}
+
+func newSegmenter(opts SegmentOptions, f Format) *segmenter {
+	return &segmenter{opts: opts, format: f}
+}
+
+// Process feeds the RMS of a chunk ending at data offset end into the segmenter.
+// It returns true when the current segment ends there. Cutting in a pause keeps
+// words whole, so MaxLength is only a fallback for speech without pauses.
+func (s *segmenter) Process(rms float64, chunkSize int, end uint32) bool {
+	if rms < s.opts.Threshold {
+		s.quiet += s.format.Duration(int64(chunkSize))
+	} else {
+		s.quiet = 0
+	}
+	length := s.format.Duration(int64(end - s.start))
+	if (length >= s.opts.MinLength && s.quiet >= s.opts.Silence) || (s.opts.MaxLength > 0 && length >= s.opts.MaxLength) {
+		s.start = end
+		s.quiet = 0
+		return true
+	}
+	return false
+}
+
+// ExtractSegment writes the audio between the data offsets start and end (in
+// bytes) of the WAV file src to dst as a WAV file of its own. An end below zero
+// means the end of the data. src may still be being recorded to.
+// It returns the duration of the segment.
+func ExtractSegment(src, dst string, start, end int64) (time.Duration, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	defer in.Close()
+
+	reader := bufio.NewReader(in)
+	format, _, err := readWavHeader(reader)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := io.CopyN(io.Discard, reader, start); err != nil {
+		return 0, fmt.Errorf("failed to seek to segment: %w", err)
+	}
+	var data io.Reader = reader
+	if end >= 0 {
+		data = io.LimitReader(reader, end-start)
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create segment file: %w", err)
+	}
+	defer out.Close()
+
+	// The header is rewritten once the length is known
+	writeWavHeader(out, format, 0)
+	n, err := io.Copy(out, data)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write segment file: %w", err)
+	}
+	if _, err := out.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to write segment file: %w", err)
+	}
+	writeWavHeader(out, format, uint32(n))
+	return format.Duration(n), nil
+}
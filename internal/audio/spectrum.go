@@ -0,0 +1,126 @@
+package audio
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// Spectrum band range. Speech energy lives between roughly 80 Hz and 8 kHz.
+const (
+	spectrumMinFreq = 80.0
+	spectrumMaxFreq = 8000.0
+	spectrumFloorDB = -75.0 // maps to 0
+	spectrumCeilDB  = -15.0 // maps to 1
+)
+
+// spectrumAnalyzer turns captured audio into levels of logarithmically spaced
+// frequency bands. It keeps a sliding window of the most recent samples, so
+// every chunk yields a spectrum regardless of its size.
+type spectrumAnalyzer struct {
+	format Format
+	window []float64 // Hann window
+	recent []float64 // most recent mono samples, oldest first
+	bands  [][2]int  // FFT bin range [lo, hi) of each band
+	buf    []complex128
+}
+
+func newSpectrumAnalyzer(f Format, numBands int) *spectrumAnalyzer {
+	// ~64ms of audio, rounded up to a power of two for the FFT
+	size := 1
+	for size < f.SampleRate/16 {
+		size <<= 1
+	}
+
+	window := make([]float64, size)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(size-1))
+	}
+
+	binHz := float64(f.SampleRate) / float64(size)
+	maxFreq := math.Min(spectrumMaxFreq, float64(f.SampleRate)/2)
+	ratio := math.Pow(maxFreq/spectrumMinFreq, 1/float64(numBands))
+	bands := make([][2]int, numBands)
+	for i := range bands {
+		lo := int(spectrumMinFreq * math.Pow(ratio, float64(i)) / binHz)
+		hi := int(spectrumMinFreq * math.Pow(ratio, float64(i+1)) / binHz)
+		// Low bands can be narrower than a bin; they still get the nearest one
+		bands[i] = [2]int{lo, max(hi, lo+1)}
+	}
+
+	return &spectrumAnalyzer{
+		format: f,
+		window: window,
+		recent: make([]float64, size),
+		bands:  bands,
+		buf:    make([]complex128, size),
+	}
+}
+
+// Process adds pcm to the analysis window and returns the band levels (0.0-1.0).
+func (s *spectrumAnalyzer) Process(pcm []byte) []float64 {
+	// Mix down to mono and slide the window
+	sampleSize := s.format.BitDepth / 8
+	block := s.format.BlockAlign()
+	numFrames := len(pcm) / block
+	if numFrames >= len(s.recent) {
+		pcm = pcm[(numFrames-len(s.recent))*block:]
+		numFrames = len(s.recent)
+	}
+	copy(s.recent, s.recent[numFrames:])
+	tail := s.recent[len(s.recent)-numFrames:]
+	for i := range tail {
+		var sum float64
+		for ch := 0; ch < s.format.Channels; ch++ {
+			sum += decodeSample(pcm[i*block+ch*sampleSize:], s.format.BitDepth)
+		}
+		tail[i] = sum / float64(s.format.Channels)
+	}
+
+	for i, v := range s.recent {
+		s.buf[i] = complex(v*s.window[i], 0)
+	}
+	fft(s.buf)
+
+	// A full scale sine under a Hann window peaks at size/4
+	ref := float64(len(s.buf)) / 4
+	levels := make([]float64, len(s.bands))
+	for i, band := range s.bands {
+		var peak float64
+		for bin := band[0]; bin < band[1] && bin < len(s.buf)/2; bin++ {
+			peak = math.Max(peak, cmplx.Abs(s.buf[bin]))
+		}
+		db := 20 * math.Log10(peak/ref+1e-12)
+		levels[i] = math.Max(0, math.Min(1, (db-spectrumFloorDB)/(spectrumCeilDB-spectrumFloorDB)))
+	}
+	return levels
+}
+
+// fft computes the discrete Fourier transform of x in place. len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+
+	// Bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	// Iterative radix-2 butterflies
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
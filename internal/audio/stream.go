@@ -0,0 +1,75 @@
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrStreamOverflow fails a stream whose receiver has fallen too far behind the recording.
+var ErrStreamOverflow = errors.New("stream fell behind the recording")
+
+// streamQueueChunks bounds the audio waiting to be written to a stream, about
+// 10s in chunks of ~50ms.
+const streamQueueChunks = 200
+
+// streamWriter writes audio to a stream from its own goroutine, so that a slow
+// receiver never blocks the capture.
+type streamWriter struct {
+	w     io.Writer
+	queue chan []byte
+	done  chan struct{}
+}
+
+func newStreamWriter(w io.Writer) *streamWriter {
+	s := &streamWriter{
+		w:     w,
+		queue: make(chan []byte, streamQueueChunks),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *streamWriter) run() {
+	defer close(s.done)
+	failed := false
+	for p := range s.queue {
+		if failed {
+			continue
+		}
+		if _, err := s.w.Write(p); err != nil {
+			// The receiving end has gone away, discard the rest
+			fmt.Fprintf(os.Stderr, "[Recorder] Error writing to stream, dropping it: %v\n", err)
+			failed = true
+		}
+	}
+}
+
+// Write queues a copy of p without blocking. It returns false if the queue is full.
+func (s *streamWriter) Write(p []byte) bool {
+	select {
+	case s.queue <- bytes.Clone(p):
+		return true
+	default:
+		return false
+	}
+}
+
+// Close waits until the queued audio has been written.
+func (s *streamWriter) Close() {
+	close(s.queue)
+	<-s.done
+}
+
+// Abort discards the queued audio and fails the stream with ErrStreamOverflow
+// if it can be closed with an error, like an *io.PipeWriter, so the receiver
+// does not mistake the audio so far for the whole recording.
+func (s *streamWriter) Abort() {
+	if c, ok := s.w.(interface{ CloseWithError(error) error }); ok {
+		c.CloseWithError(ErrStreamOverflow)
+	}
+	close(s.queue)
+}
@@ -0,0 +1,69 @@
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// TrimSilence removes leading and trailing silence from a WAV file written by Recorder.
+// Audio is analysed in 20ms windows; windows with an RMS (0.0-1.0) below threshold
+// count as silence. margin of audio is kept on both sides of the detected speech so
+// that soft word onsets and endings are not cut off.
+// If the whole file is silent it is left untouched.
+// It returns the duration of audio that was removed.
+func TrimSilence(filename string, threshold float64, margin time.Duration) (time.Duration, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read audio file: %w", err)
+	}
+	if len(content) <= wavHeaderSize {
+		return 0, nil
+	}
+	reader := bytes.NewReader(content)
+	format, _, err := readWavHeader(reader)
+	if err != nil {
+		return 0, err
+	}
+	data := content[len(content)-reader.Len():]
+	data = data[:len(data)-len(data)%format.BlockAlign()] // whole frames only
+
+	windowBytes := max(format.Bytes(20*time.Millisecond), format.BlockAlign())
+	first, last := -1, -1
+	for off := 0; off < len(data); off += windowBytes {
+		end := min(off+windowBytes, len(data))
+		if windowRMS(data[off:end], format) >= threshold {
+			if first < 0 {
+				first = off
+			}
+			last = end
+		}
+	}
+	if first < 0 {
+		return 0, nil
+	}
+
+	marginBytes := format.Bytes(margin)
+	start := max(first-marginBytes, 0)
+	stop := min(last+marginBytes, len(data))
+	if start == 0 && stop == len(data) {
+		return 0, nil
+	}
+
+	trimmed := data[start:stop]
+	removed := format.Duration(int64(len(data) - len(trimmed)))
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rewrite audio file: %w", err)
+	}
+	defer file.Close()
+
+	writeWavHeader(file, format, uint32(len(trimmed)))
+	if _, err := file.Write(trimmed); err != nil {
+		return 0, fmt.Errorf("failed to write trimmed audio: %w", err)
+	}
+
+	return removed, nil
+}
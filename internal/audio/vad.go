@@ -0,0 +1,53 @@
+package audio
+
+import "time"
+
+// VAD is a simple energy-based voice activity detector.
+// It is fed the RMS level of each recorded chunk and reports the end of speech
+// once a configurable amount of silence follows a minimum amount of speech.
+type VAD struct {
+	threshold float64
+	minSpeech time.Duration
+	silence   time.Duration
+
+	speech time.Duration // accumulated duration of chunks above threshold
+	quiet  time.Duration // duration of the current run of silent chunks
+	fired  bool
+}
+
+// NewVAD creates a detector. Chunks with an RMS (0.0-1.0) above threshold count as speech.
+// End of speech is reported after at least minSpeech of speech has been heard,
+// followed by silence lasting the silence window.
+func NewVAD(threshold float64, minSpeech, silence time.Duration) *VAD {
+	return &VAD{
+		threshold: threshold,
+		minSpeech: minSpeech,
+		silence:   silence,
+	}
+}
+
+// Process feeds the RMS of a chunk of the given duration into the detector.
+// It returns true exactly once, on the chunk where end of speech is detected.
+func (v *VAD) Process(rms float64, d time.Duration) bool {
+	if v.fired {
+		return false
+	}
+
+	if rms >= v.threshold {
+		v.speech += d
+		v.quiet = 0
+		return false
+	}
+
+	// Silence before the user started speaking does not count
+	if v.speech < v.minSpeech {
+		return false
+	}
+
+	v.quiet += d
+	if v.quiet >= v.silence {
+		v.fired = true
+		return true
+	}
+	return false
+}
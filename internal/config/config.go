@@ -19,25 +19,144 @@ type FocusConfig struct {
 	RestoreFocusCmd string `json:"restore_focus_cmd"`
 }
 
+type OpenAIConfig struct {
+	BaseURL        string   `json:"base_url"`
+	Model          string   `json:"model"`
+	Prompt         string   `json:"prompt"`
+	Temperature    *float64 `json:"temperature"`
+	ResponseFormat string   `json:"response_format"`
+}
+
+type WhisperCppConfig struct {
+	URL string `json:"url"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int `json:"max_attempts"`
+	InitialDelayMs int `json:"initial_delay_ms"`
+	MaxDelayMs     int `json:"max_delay_ms"`
+	DeadlineMs     int `json:"deadline_ms"`
+}
+
+type STTConfig struct {
+	Provider   string           `json:"provider"`
+	Stream     bool             `json:"stream"`
+	Retry      RetryConfig      `json:"retry"`
+	OpenAI     OpenAIConfig     `json:"openai"`
+	WhisperCpp WhisperCppConfig `json:"whisper_cpp"`
+}
+
+type VADConfig struct {
+	Enabled     bool    `json:"enabled"`
+	Threshold   float64 `json:"threshold"`
+	SilenceMs   int     `json:"silence_ms"`
+	MinSpeechMs int     `json:"min_speech_ms"`
+}
+
+type TrimConfig struct {
+	Enabled   bool    `json:"enabled"`
+	Threshold float64 `json:"threshold"`
+	MarginMs  int     `json:"margin_ms"`
+}
+
+type SegmentConfig struct {
+	Enabled     bool    `json:"enabled"`
+	Threshold   float64 `json:"threshold"`
+	SilenceMs   int     `json:"silence_ms"`
+	MinLengthMs int     `json:"min_length_ms"`
+	MaxLengthMs int     `json:"max_length_ms"`
+}
+
+type AudioConfig struct {
+	Backend      string        `json:"backend"`
+	Device       string        `json:"device"`
+	File         string        `json:"file"`
+	SampleRate   int           `json:"sample_rate"`
+	Channels     int           `json:"channels"`
+	BitDepth     int           `json:"bit_depth"`
+	UploadFormat string        `json:"upload_format"`
+	RejectSilent bool          `json:"reject_silent"`
+	VAD          VADConfig     `json:"vad"`
+	Trim         TrimConfig    `json:"trim"`
+	Segment      SegmentConfig `json:"segment"`
+	PreRollMs    int           `json:"pre_roll_ms"`
+}
+
+type HistoryConfig struct {
+	Enabled    bool `json:"enabled"`
+	MaxEntries int  `json:"max_entries"`
+}
+
 type Config struct {
-	OpenAIAPIKey string       `json:"openai_api_key"`
-	Language     string       `json:"language"`
-	Visual       VisualConfig `json:"visual"`
-	Focus        FocusConfig  `json:"focus"`
+	OpenAIAPIKey  string        `json:"openai_api_key"`
+	Language      string        `json:"language"`
+	Mode          string        `json:"mode"`
+	MinDurationMs int           `json:"min_duration_ms"`
+	MaxDurationMs int           `json:"max_duration_ms"`
+	STT           STTConfig     `json:"stt"`
+	Audio         AudioConfig   `json:"audio"`
+	Visual        VisualConfig  `json:"visual"`
+	Focus         FocusConfig   `json:"focus"`
+	History       HistoryConfig `json:"history"`
 }
 
 func LoadConfig() (*Config, error) {
 	cfg := &Config{
+		Mode:          "toggle",
+		MinDurationMs: 300,
+		MaxDurationMs: 60000,
+		STT: STTConfig{
+			Provider: "openai",
+			Retry: RetryConfig{
+				MaxAttempts:    4,
+				InitialDelayMs: 500,
+				MaxDelayMs:     8000,
+				DeadlineMs:     120000,
+			},
+			OpenAI: OpenAIConfig{
+				BaseURL:        "https://api.openai.com/v1",
+				Model:          "whisper-1",
+				ResponseFormat: "json",
+			},
+			WhisperCpp: WhisperCppConfig{
+				URL: "http://127.0.0.1:8080",
+			},
+		},
+		Audio: AudioConfig{
+			Backend:      "auto",
+			SampleRate:   16000,
+			Channels:     1,
+			BitDepth:     16,
+			UploadFormat: "wav",
+			VAD: VADConfig{
+				Threshold:   0.015,
+				SilenceMs:   1500,
+				MinSpeechMs: 200,
+			},
+			Trim: TrimConfig{
+				Threshold: 0.01,
+				MarginMs:  300,
+			},
+			Segment: SegmentConfig{
+				Threshold:   0.01,
+				SilenceMs:   500,
+				MinLengthMs: 20000,
+				MaxLengthMs: 60000,
+			},
+		},
 		Visual: VisualConfig{
 			BarCount:       32,
 			BarColorStart:  "#00FFFF", // Cyan
 			BarColorEnd:    "#8A2BE2", // BlueViolet
 			AnimationSpeed: 1.0,
 		},
+		History: HistoryConfig{
+			MaxEntries: 200,
+		},
 	}
 
 	// Check Config File
-	configPath, err := getConfigPath()
+	configPath, err := Path()
 	if err != nil {
 		return cfg, nil
 	}
@@ -64,6 +183,81 @@ func LoadConfig() (*Config, error) {
 	if cfg.Language == "" {
 		cfg.Language = "zh"
 	}
+	if cfg.Mode == "" {
+		cfg.Mode = "toggle"
+	}
+	if cfg.MinDurationMs == 0 {
+		cfg.MinDurationMs = 300
+	}
+	if cfg.MaxDurationMs == 0 {
+		cfg.MaxDurationMs = 60000
+	}
+	if cfg.STT.Provider == "" {
+		cfg.STT.Provider = "openai"
+	}
+	if cfg.STT.Retry.MaxAttempts == 0 {
+		cfg.STT.Retry.MaxAttempts = 4
+	}
+	if cfg.STT.Retry.InitialDelayMs == 0 {
+		cfg.STT.Retry.InitialDelayMs = 500
+	}
+	if cfg.STT.Retry.MaxDelayMs == 0 {
+		cfg.STT.Retry.MaxDelayMs = 8000
+	}
+	if cfg.STT.Retry.DeadlineMs == 0 {
+		cfg.STT.Retry.DeadlineMs = 120000
+	}
+	if cfg.STT.OpenAI.BaseURL == "" {
+		cfg.STT.OpenAI.BaseURL = "https://api.openai.com/v1"
+	}
+	if cfg.STT.OpenAI.Model == "" {
+		cfg.STT.OpenAI.Model = "whisper-1"
+	}
+	if cfg.STT.OpenAI.ResponseFormat == "" {
+		cfg.STT.OpenAI.ResponseFormat = "json"
+	}
+	if cfg.STT.WhisperCpp.URL == "" {
+		cfg.STT.WhisperCpp.URL = "http://127.0.0.1:8080"
+	}
+	if cfg.Audio.SampleRate == 0 {
+		cfg.Audio.SampleRate = 16000
+	}
+	if cfg.Audio.Channels == 0 {
+		cfg.Audio.Channels = 1
+	}
+	if cfg.Audio.BitDepth == 0 {
+		cfg.Audio.BitDepth = 16
+	}
+	if cfg.Audio.UploadFormat == "" {
+		cfg.Audio.UploadFormat = "wav"
+	}
+	if cfg.Audio.VAD.Threshold == 0 {
+		cfg.Audio.VAD.Threshold = 0.015
+	}
+	if cfg.Audio.VAD.SilenceMs == 0 {
+		cfg.Audio.VAD.SilenceMs = 1500
+	}
+	if cfg.Audio.VAD.MinSpeechMs == 0 {
+		cfg.Audio.VAD.MinSpeechMs = 200
+	}
+	if cfg.Audio.Trim.Threshold == 0 {
+		cfg.Audio.Trim.Threshold = 0.01
+	}
+	if cfg.Audio.Trim.MarginMs == 0 {
+		cfg.Audio.Trim.MarginMs = 300
+	}
+	if cfg.Audio.Segment.Threshold == 0 {
+		cfg.Audio.Segment.Threshold = 0.01
+	}
+	if cfg.Audio.Segment.SilenceMs == 0 {
+		cfg.Audio.Segment.SilenceMs = 500
+	}
+	if cfg.Audio.Segment.MinLengthMs == 0 {
+		cfg.Audio.Segment.MinLengthMs = 20000
+	}
+	if cfg.Audio.Segment.MaxLengthMs == 0 {
+		cfg.Audio.Segment.MaxLengthMs = 60000
+	}
 	if cfg.Visual.BarCount == 0 {
 		cfg.Visual.BarCount = 32
 	}
@@ -76,11 +270,15 @@ func LoadConfig() (*Config, error) {
 	if cfg.Visual.AnimationSpeed == 0 {
 		cfg.Visual.AnimationSpeed = 1.0
 	}
+	if cfg.History.MaxEntries == 0 {
+		cfg.History.MaxEntries = 200
+	}
 
 	return cfg, nil
 }
 
-func getConfigPath() (string, error) {
+// Path returns the location of the config file.
+func Path() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -0,0 +1,199 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Commands understood by a running instance.
+const (
+	CmdToggle = "toggle"
+	CmdStart  = "start"
+	CmdStop   = "stop"
+	CmdCancel = "cancel"
+	CmdPause  = "pause"
+	CmdResume = "resume"
+	CmdStatus = "status"
+)
+
+// States reported by a running instance.
+const (
+	StateIdle         = "idle"
+	StateStarting     = "starting"
+	StateRecording    = "recording"
+	StatePaused       = "paused"
+	StateTranscribing = "transcribing"
+	StatePasting      = "pasting"
+	StateDone         = "done"
+)
+
+const socketName = "wkey.sock"
+
+var (
+	// ErrAlreadyRunning is returned by Listen when another instance owns the socket.
+	ErrAlreadyRunning = errors.New("another instance is already running")
+	// ErrNotRunning is returned by Send when no instance is listening.
+	ErrNotRunning = errors.New("no running instance")
+)
+
+// Request is a single command sent to the running instance.
+type Request struct {
+	Command string `json:"command"`
+}
+
+// Response is the reply to a Request.
+type Response struct {
+	OK      bool   `json:"ok"`
+	State   string `json:"state,omitempty"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	PID     int    `json:"pid,omitempty"`
+}
+
+// Handler processes a request and returns the reply to send back.
+type Handler func(Request) Response
+
+// SocketPath returns the location of the control socket.
+func SocketPath() string {
+	// Use XDG_RUNTIME_DIR if available, else /tmp with a per-user name
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, socketName)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("wkey-%d.sock", os.Getuid()))
+}
+
+// Server accepts commands on the control socket.
+type Server struct {
+	listener net.Listener
+	path     string
+	handler  Handler
+}
+
+// Listen creates the control socket at path. If another instance is answering on it,
+// ErrAlreadyRunning is returned. A stale socket left behind by a crashed instance is replaced.
+func Listen(path string, handler Handler) (*Server, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil && errors.Is(err, syscall.EADDRINUSE) {
+		conn, dialErr := net.DialTimeout("unix", path, time.Second)
+		if dialErr == nil {
+			conn.Close()
+			return nil, ErrAlreadyRunning
+		}
+		// Nobody is listening, the socket file is stale
+		os.Remove(path)
+		listener, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			// Lost the race against another instance starting at the same time
+			return nil, ErrAlreadyRunning
+		}
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict control socket permissions: %w", err)
+	}
+
+	return &Server{listener: listener, path: path, handler: handler}, nil
+}
+
+// Serve accepts connections until Close is called.
+func (s *Server) Serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("[Control] Accept failed: %v\n", err)
+			continue
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops accepting commands and removes the socket file.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return
+	}
+
+	req, err := parseRequest(line)
+	var resp Response
+	if err != nil {
+		resp = Response{Error: err.Error()}
+	} else {
+		fmt.Printf("[Control] Received command: %s\n", req.Command)
+		resp = s.handler(req)
+	}
+
+	data, _ := json.Marshal(resp)
+	conn.Write(append(data, '\n'))
+}
+
+// parseRequest accepts either a JSON request or a bare command word,
+// so that the socket can also be driven with tools like socat.
+func parseRequest(line string) (Request, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var req Request
+		if err := json.Unmarshal([]byte(line), &req); err != nil {
+			return Request{}, fmt.Errorf("invalid request: %w", err)
+		}
+		return req, nil
+	}
+	if line == "" {
+		return Request{}, fmt.Errorf("empty request")
+	}
+	return Request{Command: line}, nil
+}
+
+// Send delivers a command to the running instance and waits for its reply.
+// ErrNotRunning is returned if no instance is listening on path.
+func Send(path string, command string) (Response, error) {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return Response{}, ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	data, err := json.Marshal(Request{Command: command})
+	if err != nil {
+		return Response{}, err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return Response{}, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil && line == "" {
+		return Response{}, fmt.Errorf("failed to read reply: %w", err)
+	}
+
+	var resp Response
+	if err := json.Unmarshal([]byte(line), &resp); err != nil {
+		return Response{}, fmt.Errorf("invalid reply: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,122 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry is a single transcription stored in the history file.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Text     string    `json:"text"`
+	Provider string    `json:"provider,omitempty"`
+}
+
+// Path returns the location of the history file,
+// $XDG_DATA_HOME/wkey/history.jsonl or ~/.local/share/wkey/history.jsonl.
+func Path() (string, error) {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dir, "wkey", "history.jsonl"), nil
+}
+
+// Append adds an entry to the history file at path, creating it if needed.
+// If maxEntries is positive, only the most recent maxEntries entries are kept.
+func Append(path string, entry Entry, maxEntries int) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %w", err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write history entry: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close history file: %w", err)
+	}
+
+	if maxEntries <= 0 {
+		return nil
+	}
+	entries, err := Load(path)
+	if err != nil || len(entries) <= maxEntries {
+		return err
+	}
+	return write(path, entries[len(entries)-maxEntries:])
+}
+
+// Load reads all entries from the history file at path, oldest first.
+// A missing file yields no entries.
+func Load(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip lines damaged by an interrupted write
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %w", err)
+	}
+	return entries, nil
+}
+
+// write replaces the history file with entries, going through a temporary
+// file so that a crash cannot lose the whole history.
+func write(path string, entries []Entry) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".history-*.jsonl")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary history file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, entry := range entries {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to encode history entry: %w", err)
+		}
+		writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write history file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close history file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,86 @@
+package stt
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Kinds of failed transcription requests. An *APIError matches exactly one of
+// them with errors.Is.
+var (
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrQuotaExhausted  = errors.New("quota exhausted")
+	ErrPayloadTooLarge = errors.New("payload too large")
+	ErrBadRequest      = errors.New("request rejected")
+	ErrNetwork         = errors.New("network error")
+	ErrServer          = errors.New("server error")
+)
+
+// APIError describes a transcription request that failed, either with an error
+// response from the provider or without reaching it at all.
+type APIError struct {
+	Kind       error  // one of the Err* kinds above
+	StatusCode int    // HTTP status, 0 if no response was received
+	Message    string // error message of the provider, if any
+	Err        error  // underlying error of a request without response
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Kind.Error())
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " (HTTP %d)", e.StatusCode)
+	}
+	if e.Err != nil {
+		b.WriteString(": " + e.Err.Error())
+	}
+	return b.String()
+}
+
+func (e *APIError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// newStatusError classifies an error response by its status code.
+func newStatusError(statusCode int, message string) *APIError {
+	kind := ErrBadRequest
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		kind = ErrUnauthorized
+	case statusCode == http.StatusPaymentRequired:
+		kind = ErrQuotaExhausted
+	case statusCode == http.StatusRequestEntityTooLarge:
+		kind = ErrPayloadTooLarge
+	case statusCode == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case statusCode >= 500:
+		kind = ErrServer
+	}
+	return &APIError{Kind: kind, StatusCode: statusCode, Message: message}
+}
+
+// responseMessage returns the body of an error response that is not in the
+// provider's error format, such as the HTML page of a proxy, for APIError.Message.
+func responseMessage(body []byte) string {
+	message := strings.TrimSpace(string(body))
+	if len(message) > 200 {
+		message = message[:200] + "..."
+	}
+	return message
+}
+
+// retryable reports whether a request that failed with err may succeed when
+// sent again: rate limits, server and network errors are usually transient.
+func retryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrServer) || errors.Is(err, ErrNetwork)
+}
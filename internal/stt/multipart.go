@@ -0,0 +1,231 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// createAudioPart adds the "file" field of a transcription request. Unlike
+// multipart.Writer.CreateFormFile it sets a content type matching the file
+// extension, so servers can tell compressed uploads apart.
+func createAudioPart(writer *multipart.Writer, filename string) (io.Writer, error) {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, quoteEscaper.Replace(filepath.Base(filename))))
+	h.Set("Content-Type", audioContentType(filename))
+	return writer.CreatePart(h)
+}
+
+// audioContentType returns the MIME type of an audio file based on its extension.
+func audioContentType(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".wav":
+		return "audio/wav"
+	case ".flac":
+		return "audio/flac"
+	case ".ogg", ".opus":
+		return "audio/ogg"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".m4a", ".mp4":
+		return "audio/mp4"
+	case ".webm":
+		return "audio/webm"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// formField is a plain field of a multipart form.
+type formField struct {
+	name  string
+	value string
+}
+
+// formRequest is a transcription request carrying one audio file and plain fields.
+type formRequest struct {
+	url      string
+	header   http.Header
+	audio    io.Reader
+	filename string
+	fields   []formField
+	// timeout starts counting once audio has been read to the end
+	timeout time.Duration
+	// check returns the *APIError of a failed response, nil on success
+	check func(statusCode int, body []byte) error
+	// retry applies to rate limits, server and network errors, if audio can be rewound
+	retry retryPolicy
+}
+
+var (
+	errRequestTimeout = errors.New("request timed out")
+	errRetryDeadline  = errors.New("retry deadline exceeded")
+)
+
+// postForm sends a multipart form whose body is produced while it is being
+// sent, so the audio is never held in memory. The audio may still be recorded
+// while the request is in flight: the timeout only starts once it has been
+// read completely, and a read error aborts the request.
+// Failed requests are retried according to fr.retry if the audio is seekable,
+// like a file, and all attempts together are aborted at its deadline. A
+// recording in progress is sent only once, bounded by fr.timeout alone.
+// Cancelling ctx aborts the request, including the wait for a retry.
+// It returns the status code and the response body, and an *APIError if the
+// request failed.
+func postForm(ctx context.Context, fr formRequest) (int, []byte, error) {
+	started := time.Now()
+	seeker, canRetry := fr.audio.(io.Seeker)
+	parent := ctx
+	if canRetry && fr.retry.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, fr.retry.deadline, errRetryDeadline)
+		defer cancel()
+	}
+	for attempt := 1; ; attempt++ {
+		statusCode, header, respBody, err := postFormOnce(ctx, fr)
+		if err == nil {
+			err = fr.check(statusCode, respBody)
+		}
+		if !canRetry || parent.Err() != nil || !retryable(err) {
+			return statusCode, respBody, err
+		}
+
+		delay, ok := fr.retry.delay(attempt+1, started, header)
+		if !ok {
+			return statusCode, respBody, err
+		}
+		if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr != nil {
+			return statusCode, respBody, err
+		}
+
+		if fr.retry.onRetry != nil {
+			fr.retry.onRetry(attempt+1, fr.retry.maxAttempts, delay, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			if parent.Err() == nil {
+				// The deadline leaves no time for the retry, so the last error stands
+				return statusCode, respBody, err
+			}
+			return 0, nil, fmt.Errorf("failed to send request: %w", parent.Err())
+		}
+	}
+}
+
+// postFormOnce sends the request of postForm once. It returns the status
+// code, header and body of the response. Only a request that got no response
+// at all fails, with ErrNetwork unless ctx was cancelled other than by the
+// retry deadline.
+func postFormOnce(ctx context.Context, fr formRequest) (int, http.Header, []byte, error) {
+	reqCtx, cancel := context.WithCancelCause(ctx)
+	// The timeout is armed once the audio has been read to the end
+	timeout := time.AfterFunc(fr.timeout, func() { cancel(errRequestTimeout) })
+	timeout.Stop()
+	defer func() {
+		cancel(nil)
+		timeout.Stop()
+	}()
+
+	audio := &eofReader{r: fr.audio, onEOF: func() {
+		if reqCtx.Err() == nil {
+			timeout.Reset(fr.timeout)
+		}
+	}}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		pw.CloseWithError(writeForm(writer, fr, audio))
+	}()
+	// A file must no longer be read when it is rewound for a retry. A recording in
+	// progress is not waited for, its next write fails once the pipe is closed.
+	_, seekable := fr.audio.(io.Seeker)
+	defer func() {
+		pr.Close()
+		if seekable {
+			<-written
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(reqCtx, "POST", fr.url, pr)
+	if err != nil {
+		return 0, nil, nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range fr.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, nil, requestError(ctx, reqCtx, fr, "failed to send request", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, requestError(ctx, reqCtx, fr, "failed to read response body", err)
+	}
+	return resp.StatusCode, resp.Header, respBody, nil
+}
+
+// requestError describes a request of postFormOnce that got no response. The
+// error of a cancelled ctx is passed on; timeouts count as network errors.
+func requestError(ctx, reqCtx context.Context, fr formRequest, msg string, err error) error {
+	switch cause := context.Cause(reqCtx); {
+	case errors.Is(cause, errRequestTimeout):
+		err = fmt.Errorf("%w after %v", cause, fr.timeout)
+	case errors.Is(cause, errRetryDeadline):
+		err = fmt.Errorf("%w after %v", cause, fr.retry.deadline)
+	case ctx.Err() != nil:
+		return fmt.Errorf("%s: %w", msg, ctx.Err())
+	}
+	return &APIError{Kind: ErrNetwork, Err: fmt.Errorf("%s: %w", msg, err)}
+}
+
+func writeForm(writer *multipart.Writer, fr formRequest, audio io.Reader) error {
+	part, err := createAudioPart(writer, fr.filename)
+	if err != nil {
+		return fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return fmt.Errorf("failed to copy file content: %w", err)
+	}
+	for _, field := range fr.fields {
+		if err := writer.WriteField(field.name, field.value); err != nil {
+			return fmt.Errorf("failed to write %s field: %w", field.name, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+	return nil
+}
+
+// eofReader calls onEOF once when r has been read to the end.
+type eofReader struct {
+	r     io.Reader
+	onEOF func()
+	once  sync.Once
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		e.once.Do(e.onEOF)
+	}
+	return n, err
+}
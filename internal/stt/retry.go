@@ -0,0 +1,70 @@
+package stt
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"wkey/internal/config"
+)
+
+// retryPolicy decides whether and when a failed request is sent again.
+// The zero value makes a single attempt.
+type retryPolicy struct {
+	maxAttempts  int
+	initialDelay time.Duration // delay before the first retry, doubled for each further one
+	maxDelay     time.Duration
+	deadline     time.Duration // total time of all attempts, no limit if not positive
+	onRetry      func(attempt, maxAttempts int, delay time.Duration, err error)
+}
+
+func newRetryPolicy(cfg config.RetryConfig, onRetry func(attempt, maxAttempts int, delay time.Duration, err error)) retryPolicy {
+	return retryPolicy{
+		maxAttempts:  cfg.MaxAttempts,
+		initialDelay: time.Duration(cfg.InitialDelayMs) * time.Millisecond,
+		maxDelay:     time.Duration(cfg.MaxDelayMs) * time.Millisecond,
+		deadline:     time.Duration(cfg.DeadlineMs) * time.Millisecond,
+		onRetry:      onRetry,
+	}
+}
+
+// delay returns how long to wait before attempt, the next one after a failure.
+// A Retry-After header of the failed response takes precedence over the backoff.
+// It returns false if no further attempt should be made because the attempts are
+// used up or the wait would end past the deadline.
+func (p retryPolicy) delay(attempt int, started time.Time, header http.Header) (time.Duration, bool) {
+	if attempt > p.maxAttempts {
+		return 0, false
+	}
+
+	delay, ok := retryAfter(header)
+	if !ok {
+		// Exponential backoff with jitter, so that clients do not retry in lockstep
+		backoff := p.initialDelay << (attempt - 2)
+		if backoff > p.maxDelay || backoff <= 0 {
+			backoff = p.maxDelay
+		}
+		delay = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	}
+
+	if p.deadline > 0 && time.Since(started)+delay > p.deadline {
+		return 0, false
+	}
+	return delay, true
+}
+
+// retryAfter parses a Retry-After header, given either in seconds or as an HTTP date.
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
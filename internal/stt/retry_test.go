@@ -0,0 +1,191 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// response is a canned reply of sequenceServer.
+type response struct {
+	status     int
+	retryAfter string
+	body       string
+	delay      time.Duration
+}
+
+// sequenceServer stands in for the whisper.cpp server, giving the responses in
+// order and repeating the last one. It returns the URL and the number of requests.
+func sequenceServer(t *testing.T, responses ...response) (string, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(requests.Add(1))
+		resp := responses[min(n, len(responses))-1]
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(resp.delay):
+		case <-r.Context().Done():
+			return
+		}
+		if resp.retryAfter != "" {
+			w.Header().Set("Retry-After", resp.retryAfter)
+		}
+		w.WriteHeader(resp.status)
+		io.WriteString(w, resp.body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &requests
+}
+
+// retryingClient returns a whisper.cpp client for url with policy, recording
+// the delays of its retries.
+func retryingClient(t *testing.T, url string, policy retryPolicy, delays *[]time.Duration) *WhisperCppClient {
+	t.Helper()
+	client, err := NewWhisperCppClient(url, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.onRetry = func(attempt, maxAttempts int, delay time.Duration, err error) {
+		*delays = append(*delays, delay)
+	}
+	client.retry = policy
+	return client
+}
+
+func TestRetry(t *testing.T) {
+	quick := retryPolicy{maxAttempts: 4, initialDelay: time.Millisecond, maxDelay: time.Millisecond}
+	tests := []struct {
+		name      string
+		policy    retryPolicy
+		responses []response
+		requests  int
+		wantErr   error
+		maxDelay  time.Duration // of the retries
+	}{
+		{
+			name:      "server error then success",
+			policy:    quick,
+			responses: []response{{status: 503, body: "busy"}, {status: 200, body: `{"text":"hello"}`}},
+			requests:  2,
+			maxDelay:  time.Millisecond,
+		},
+		{
+			// The backoff alone would wait an hour
+			name:      "rate limit with Retry-After",
+			policy:    retryPolicy{maxAttempts: 4, initialDelay: time.Hour, maxDelay: time.Hour},
+			responses: []response{{status: 429, retryAfter: "0", body: `{"error":"slow down"}`}, {status: 200, body: `{"text":"hello"}`}},
+			requests:  2,
+		},
+		{
+			name:      "bad request is not retried",
+			policy:    quick,
+			responses: []response{{status: 400, body: `{"error":"invalid language"}`}},
+			requests:  1,
+			wantErr:   ErrBadRequest,
+		},
+		{
+			name:      "attempts used up",
+			policy:    quick,
+			responses: []response{{status: 500, body: "oops"}},
+			requests:  4,
+			wantErr:   ErrServer,
+			maxDelay:  time.Millisecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, requests := sequenceServer(t, tt.responses...)
+			var delays []time.Duration
+			client := retryingClient(t, url, tt.policy, &delays)
+
+			text, err := client.Transcribe(context.Background(), testAudioFile(t))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("err = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil || text != "hello" {
+				t.Errorf("got %q, %v, want hello", text, err)
+			}
+			if n := int(requests.Load()); n != tt.requests {
+				t.Errorf("%d requests, want %d", n, tt.requests)
+			}
+			if len(delays) != tt.requests-1 {
+				t.Errorf("%d retries reported, want %d", len(delays), tt.requests-1)
+			}
+			for _, d := range delays {
+				if d > tt.maxDelay {
+					t.Errorf("retried after %v, want at most %v", d, tt.maxDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryDeadline(t *testing.T) {
+	// A request hanging past the deadline is aborted, not only the retries after it
+	url, requests := sequenceServer(t, response{status: 200, body: `{"text":"late"}`, delay: 10 * time.Second})
+	var delays []time.Duration
+	client := retryingClient(t, url, retryPolicy{
+		maxAttempts:  4,
+		initialDelay: time.Millisecond,
+		maxDelay:     time.Millisecond,
+		deadline:     200 * time.Millisecond,
+	}, &delays)
+
+	start := time.Now()
+	_, err := client.Transcribe(context.Background(), testAudioFile(t))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %v with a deadline of 200ms", elapsed)
+	}
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("err = %v, want a network error", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want 1", n)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := retryPolicy{maxAttempts: 5, initialDelay: time.Second, maxDelay: 3 * time.Second, deadline: time.Minute}
+	now := time.Now()
+	tests := []struct {
+		name     string
+		attempt  int
+		started  time.Time
+		header   http.Header
+		min, max time.Duration
+		ok       bool
+	}{
+		{"first retry", 2, now, nil, 500 * time.Millisecond, time.Second, true},
+		{"backoff doubles", 3, now, nil, time.Second, 2 * time.Second, true},
+		{"backoff is capped", 5, now, nil, 1500 * time.Millisecond, 3 * time.Second, true},
+		{"attempts used up", 6, now, nil, 0, 0, false},
+		{"past the deadline", 2, now.Add(-time.Minute), nil, 0, 0, false},
+		{"Retry-After seconds", 2, now, http.Header{"Retry-After": {"7"}}, 7 * time.Second, 7 * time.Second, true},
+		{"Retry-After date", 2, now, http.Header{"Retry-After": {now.Add(10 * time.Second).UTC().Format(http.TimeFormat)}}, 8 * time.Second, 10 * time.Second, true},
+		{"Retry-After in the past", 2, now, http.Header{"Retry-After": {now.Add(-time.Hour).UTC().Format(http.TimeFormat)}}, 0, 0, true},
+		{"invalid Retry-After", 2, now, http.Header{"Retry-After": {"soon"}}, 500 * time.Millisecond, time.Second, true},
+		{"Retry-After past the deadline", 2, now, http.Header{"Retry-After": {"120"}}, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			delay, ok := p.delay(tt.attempt, tt.started, header)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && (delay < tt.min || delay > tt.max) {
+				t.Errorf("delay = %v, want between %v and %v", delay, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"wkey/internal/config"
+)
+
+// ErrMissingAPIKey is returned by providers that need an API key when none is configured.
+var ErrMissingAPIKey = errors.New("API key is missing")
+
+// Transcriber converts a recorded audio file into text.
+// Cancelling ctx aborts the request.
+type Transcriber interface {
+	Transcribe(ctx context.Context, filename string) (string, error)
+}
+
+// StreamTranscriber is implemented by providers that can upload audio while it
+// is still being recorded. TranscribeStream reads the audio from r until EOF; a
+// read error aborts the request. filename names the upload and its format.
+type StreamTranscriber interface {
+	Transcriber
+	TranscribeStream(ctx context.Context, r io.Reader, filename string) (string, error)
+}
+
+// Options carries runtime settings that come from the command line rather than the config file.
+type Options struct {
+	MockResponse string
+	Verbose      bool
+	// OnRetry is called before a failed request is sent again, with the number of
+	// the upcoming attempt, the configured maximum and the delay before it.
+	OnRetry func(attempt, maxAttempts int, delay time.Duration, err error)
+}
+
+// Factory builds a Transcriber for a provider from the loaded configuration.
+type Factory func(cfg *config.Config, opts Options) (Transcriber, error)
+
+var providers = map[string]Factory{}
+
+// Register makes a provider available under the given name.
+// It is intended to be called from the init function of the file implementing the provider.
+func Register(name string, factory Factory) {
+	if _, exists := providers[name]; exists {
+		panic("stt: provider registered twice: " + name)
+	}
+	providers[name] = factory
+}
+
+// Providers returns the names of all registered providers, sorted.
+func Providers() []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates the Transcriber selected by cfg.STT.Provider.
+// If opts.MockResponse is set, a mock transcriber is returned regardless of the provider.
+func New(cfg *config.Config, opts Options) (Transcriber, error) {
+	if opts.MockResponse != "" {
+		return &MockClient{response: opts.MockResponse}, nil
+	}
+
+	factory, ok := providers[cfg.STT.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown STT provider %q (available: %s)", cfg.STT.Provider, strings.Join(Providers(), ", "))
+	}
+	return factory(cfg, opts)
+}
+
+// MockClient returns a fixed response without touching the network.
+type MockClient struct {
+	response string
+}
+
+func (m *MockClient) Transcribe(ctx context.Context, filename string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return m.response, nil
+}
+
+func (m *MockClient) TranscribeStream(ctx context.Context, r io.Reader, filename string) (string, error) {
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return m.response, nil
+}
+
+func (m *MockClient) TranscribeTimestamps(ctx context.Context, filename string) (*Transcript, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &Transcript{
+		Text:     m.response,
+		Segments: []Segment{{Text: m.response}},
+	}, nil
+}
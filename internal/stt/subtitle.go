@@ -0,0 +1,56 @@
+package stt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteSRT writes the segments of t as SubRip subtitles, one cue per segment.
+func (t *Transcript) WriteSRT(w io.Writer) error {
+	var b strings.Builder
+	n := 0
+	for _, seg := range t.Segments {
+		if seg.Text == "" {
+			continue
+		}
+		n++
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", n, subtitleTime(seg.Start, ','), subtitleTime(seg.End, ','), seg.Text)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteVTT writes the segments of t as WebVTT subtitles, one cue per segment.
+func (t *Transcript) WriteVTT(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	for _, seg := range t.Segments {
+		if seg.Text == "" {
+			continue
+		}
+		// "-->" would end the cue timings early, so it must not appear in the text
+		text := strings.ReplaceAll(seg.Text, "-->", "->")
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n", subtitleTime(seg.Start, '.'), subtitleTime(seg.End, '.'), text)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes t as indented JSON.
+func (t *Transcript) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(t)
+}
+
+// subtitleTime formats seconds as HH:MM:SS followed by sep and milliseconds.
+func subtitleTime(seconds float64, sep byte) string {
+	ms := int64(seconds*1000 + 0.5)
+	if ms < 0 {
+		ms = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
@@ -0,0 +1,62 @@
+package stt
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubtitleTime(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		sep     byte
+		want    string
+	}{
+		{0, ',', "00:00:00,000"},
+		{1.5, ',', "00:00:01,500"},
+		{1.5, '.', "00:00:01.500"},
+		{59.9996, '.', "00:01:00.000"}, // rounds up into the next minute
+		{3599.999, ',', "00:59:59,999"},
+		{3600, ',', "01:00:00,000"},
+		{36000 + 61.25, '.', "10:01:01.250"},
+		{-0.2, ',', "00:00:00,000"},
+	}
+	for _, tt := range tests {
+		if got := subtitleTime(tt.seconds, tt.sep); got != tt.want {
+			t.Errorf("subtitleTime(%v, %q) = %s, want %s", tt.seconds, tt.sep, got, tt.want)
+		}
+	}
+}
+
+var subtitleTranscript = &Transcript{
+	Text: "Hello there. A --> B",
+	Segments: []Segment{
+		{Start: 0, End: 1.2, Text: "Hello there."},
+		{Start: 1.2, End: 2, Text: ""}, // no speech, no cue
+		{Start: 2, End: 3661.5, Text: "A --> B"},
+	},
+}
+
+func TestWriteSRT(t *testing.T) {
+	var b strings.Builder
+	if err := subtitleTranscript.WriteSRT(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "1\n00:00:00,000 --> 00:00:01,200\nHello there.\n\n" +
+		"2\n00:00:02,000 --> 01:01:01,500\nA --> B\n\n"
+	if b.String() != want {
+		t.Errorf("WriteSRT =\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var b strings.Builder
+	if err := subtitleTranscript.WriteVTT(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n\n" +
+		"00:00:00.000 --> 00:00:01.200\nHello there.\n\n" +
+		"00:00:02.000 --> 01:01:01.500\nA -> B\n\n"
+	if b.String() != want {
+		t.Errorf("WriteVTT =\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,80 @@
+package stt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// TimestampTranscriber is implemented by providers that can report when each
+// part of the transcript was spoken, as needed for subtitles.
+type TimestampTranscriber interface {
+	Transcriber
+	TranscribeTimestamps(ctx context.Context, filename string) (*Transcript, error)
+}
+
+// Transcript is a transcription with segment and word timestamps.
+// All times are in seconds from the start of the audio.
+type Transcript struct {
+	Text     string    `json:"text"`
+	Language string    `json:"language,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Segments []Segment `json:"segments"`
+	Words    []Word    `json:"words,omitempty"`
+}
+
+// Segment is a phrase of a transcript, the unit of a subtitle cue.
+type Segment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+	Words []Word  `json:"words,omitempty"`
+}
+
+// Word is a single word of a transcript.
+type Word struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Word  string  `json:"word"`
+}
+
+// parseVerboseJSON reads a verbose_json response. OpenAI reports words for the
+// whole transcript and whisper.cpp per segment, so both are filled in from the other.
+func parseVerboseJSON(body []byte) (*Transcript, error) {
+	var t Transcript
+	if err := json.Unmarshal(body, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	t.Text = strings.TrimSpace(t.Text)
+	haveSegmentWords := false
+	for i := range t.Segments {
+		seg := &t.Segments[i]
+		seg.Text = strings.TrimSpace(seg.Text)
+		for j := range seg.Words {
+			seg.Words[j].Word = strings.TrimSpace(seg.Words[j].Word)
+		}
+		haveSegmentWords = haveSegmentWords || len(seg.Words) > 0
+	}
+	for i := range t.Words {
+		t.Words[i].Word = strings.TrimSpace(t.Words[i].Word)
+	}
+
+	switch {
+	case haveSegmentWords && len(t.Words) == 0:
+		for _, seg := range t.Segments {
+			t.Words = append(t.Words, seg.Words...)
+		}
+	case !haveSegmentWords && len(t.Words) > 0:
+		next := 0
+		for i := range t.Segments {
+			seg := &t.Segments[i]
+			for next < len(t.Words) && (t.Words[next].Start < seg.End || i == len(t.Segments)-1) {
+				seg.Words = append(seg.Words, t.Words[next])
+				next++
+			}
+		}
+	}
+	return &t, nil
+}
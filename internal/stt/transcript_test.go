@@ -0,0 +1,63 @@
+package stt
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseVerboseJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Transcript
+	}{
+		{
+			// OpenAI: words for the whole transcript
+			name: "transcript words",
+			body: `{"text":" Hi there. Bye.","language":"english","duration":3.5,
+				"segments":[{"start":0,"end":1.5,"text":" Hi there."},{"start":2,"end":3,"text":" Bye."}],
+				"words":[{"start":0,"end":0.5,"word":"Hi"},{"start":0.6,"end":1.4,"word":"there"},{"start":2.1,"end":2.8,"word":"Bye"}]}`,
+			want: Transcript{
+				Text: "Hi there. Bye.", Language: "english", Duration: 3.5,
+				Segments: []Segment{
+					{Start: 0, End: 1.5, Text: "Hi there.", Words: []Word{{0, 0.5, "Hi"}, {0.6, 1.4, "there"}}},
+					{Start: 2, End: 3, Text: "Bye.", Words: []Word{{2.1, 2.8, "Bye"}}},
+				},
+				Words: []Word{{0, 0.5, "Hi"}, {0.6, 1.4, "there"}, {2.1, 2.8, "Bye"}},
+			},
+		},
+		{
+			// whisper.cpp: words per segment
+			name: "segment words",
+			body: `{"text":" Hi there.\n","segments":[{"start":0,"end":1.5,"text":" Hi there.\n",
+				"words":[{"start":0,"end":0.5,"word":" Hi"},{"start":0.6,"end":1.4,"word":" there."}]}]}`,
+			want: Transcript{
+				Text: "Hi there.",
+				Segments: []Segment{
+					{Start: 0, End: 1.5, Text: "Hi there.", Words: []Word{{0, 0.5, "Hi"}, {0.6, 1.4, "there."}}},
+				},
+				Words: []Word{{0, 0.5, "Hi"}, {0.6, 1.4, "there."}},
+			},
+		},
+		{
+			name: "no words",
+			body: `{"text":"Hi","segments":[{"start":0,"end":1,"text":"Hi"}]}`,
+			want: Transcript{Text: "Hi", Segments: []Segment{{Start: 0, End: 1, Text: "Hi"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseVerboseJSON([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("parseVerboseJSON =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseVerboseJSON([]byte("Hi there.")); err == nil {
+		t.Errorf("plain text accepted as verbose_json")
+	}
+}
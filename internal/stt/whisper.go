@@ -1,137 +1,204 @@
 package stt
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+
+	"wkey/internal/config"
 )
 
-type Client struct {
-	apiKey       string
-	language     string
-	mockResponse string
-	verbose      bool
+func init() {
+	Register("openai", func(cfg *config.Config, opts Options) (Transcriber, error) {
+		client, err := NewOpenAIClient(cfg.OpenAIAPIKey, cfg.Language, cfg.STT.OpenAI, opts.Verbose)
+		if err != nil {
+			return nil, err
+		}
+		client.retry = newRetryPolicy(cfg.STT.Retry, opts.OnRetry)
+		return client, nil
+	})
+}
+
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// OpenAIClient transcribes audio with the OpenAI Whisper API, or with any
+// server that speaks the same multipart /audio/transcriptions API
+// (Groq, LocalAI, vLLM, internal gateways).
+type OpenAIClient struct {
+	apiKey   string
+	language string
+	settings config.OpenAIConfig
+	verbose  bool
+	retry    retryPolicy
 }
 
-func NewClient(apiKey string, language string, mockResponse string, verbose bool) (*Client, error) {
-	// If mockResponse is provided, we don't strictly need the API key
-	if mockResponse == "" && os.Getenv("APP_ENV") != "dev" && apiKey == "" {
-		return nil, fmt.Errorf("OpenAI API key is missing. Please set OPENAI_API_KEY env var or configure it in ~/.config/wkey/config.json")
+func NewOpenAIClient(apiKey string, language string, settings config.OpenAIConfig, verbose bool) (*OpenAIClient, error) {
+	if settings.BaseURL == "" {
+		settings.BaseURL = defaultOpenAIBaseURL
+	}
+	settings.BaseURL = strings.TrimSuffix(settings.BaseURL, "/")
+	if settings.Model == "" {
+		settings.Model = "whisper-1"
+	}
+
+	// Self-hosted servers often run without authentication, so only the official API requires a key
+	if os.Getenv("APP_ENV") != "dev" && apiKey == "" && settings.BaseURL == defaultOpenAIBaseURL {
+		return nil, fmt.Errorf("OpenAI %w. Please set OPENAI_API_KEY env var or configure it in ~/.config/wkey/config.json", ErrMissingAPIKey)
 	}
 
-	return &Client{apiKey: apiKey, language: language, mockResponse: mockResponse, verbose: verbose}, nil
+	switch settings.ResponseFormat {
+	case "", "json", "verbose_json", "text", "srt", "vtt":
+	default:
+		return nil, fmt.Errorf("unsupported response_format %q", settings.ResponseFormat)
+	}
+
+	return &OpenAIClient{apiKey: apiKey, language: language, settings: settings, verbose: verbose}, nil
 }
 
 type transcriptionResponse struct {
-	Text string `json:"text"`
+	Text  string `json:"text"`
 	Error *struct {
 		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    any    `json:"code"` // a string, or null
 	} `json:"error,omitempty"`
 }
 
-func (c *Client) Transcribe(filename string) (string, error) {
-	if c.mockResponse != "" {
-		return c.mockResponse, nil
-	}
-
-	if c.verbose {
-		keyLen := len(c.apiKey)
-		maskedKey := "missing"
-		if keyLen > 8 {
-			maskedKey = c.apiKey[:4] + "..." + c.apiKey[keyLen-4:]
-		}
-		fmt.Printf("Transcribing %s (Language: %s, API Key: %s)\n", filename, c.language, maskedKey)
-	}
-
+func (c *OpenAIClient) Transcribe(ctx context.Context, filename string) (string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		return "", fmt.Errorf("failed to open audio file: %w", err)
 	}
 	defer file.Close()
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+	return c.TranscribeStream(ctx, file, filename)
+}
 
-	// Add file field
-	part, err := writer.CreateFormFile("file", filepath.Base(filename))
+// TranscribeStream uploads audio while it is read from r, so a recording can be
+// sent while it is still in progress. filename names the upload and its format.
+func (c *OpenAIClient) TranscribeStream(ctx context.Context, r io.Reader, filename string) (string, error) {
+	respBody, err := c.post(ctx, r, filename, c.settings.ResponseFormat)
 	if err != nil {
-		return "", fmt.Errorf("failed to create form file: %w", err)
+		return "", err
 	}
-	_, err = io.Copy(part, file)
-	if err != nil {
-		return "", fmt.Errorf("failed to copy file content: %w", err)
+
+	// Plain text formats carry the transcript (or subtitles) directly in the body
+	switch c.settings.ResponseFormat {
+	case "text", "srt", "vtt":
+		return strings.TrimSpace(string(respBody)), nil
 	}
 
-	// Add model field
-	err = writer.WriteField("model", "whisper-1")
-	if err != nil {
-		return "", fmt.Errorf("failed to write model field: %w", err)
+	var result transcriptionResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
 	}
-	
-	// Add language field
-	err = writer.WriteField("language", c.language) 
-	if err != nil {
-		return "", fmt.Errorf("failed to write language field: %w", err)
+
+	if result.Error != nil {
+		return "", newStatusError(http.StatusOK, result.Error.Message)
 	}
 
-	err = writer.Close()
+	return result.Text, nil
+}
+
+// TranscribeTimestamps transcribes a file with segment and word timestamps.
+// The gpt-4o-* models do not support them; whisper-1 does.
+func (c *OpenAIClient) TranscribeTimestamps(ctx context.Context, filename string) (*Transcript, error) {
+	file, err := os.Open(filename)
 	if err != nil {
-		return "", fmt.Errorf("failed to close multipart writer: %w", err)
+		return nil, fmt.Errorf("failed to open audio file: %w", err)
 	}
+	defer file.Close()
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/audio/transcriptions", body)
+	respBody, err := c.post(ctx, file, filename, "verbose_json",
+		formField{"timestamp_granularities[]", "segment"},
+		formField{"timestamp_granularities[]", "word"},
+	)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
+	return parseVerboseJSON(respBody)
+}
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+// post sends a transcription request asking for responseFormat and returns the
+// body of a successful response.
+func (c *OpenAIClient) post(ctx context.Context, r io.Reader, filename string, responseFormat string, extra ...formField) ([]byte, error) {
+	if c.verbose {
+		keyLen := len(c.apiKey)
+		maskedKey := "missing"
+		if keyLen > 8 {
+			maskedKey = c.apiKey[:4] + "..." + c.apiKey[keyLen-4:]
+		}
+		fmt.Printf("Transcribing %s (Model: %s, Language: %s, URL: %s, API Key: %s)\n", filename, c.settings.Model, c.language, c.settings.BaseURL, maskedKey)
+	}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+	fields := []formField{
+		{"model", c.settings.Model},
+		{"language", c.language},
 	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+
+	// Add optional fields
+	if c.settings.Prompt != "" {
+		fields = append(fields, formField{"prompt", c.settings.Prompt})
+	}
+	if c.settings.Temperature != nil {
+		fields = append(fields, formField{"temperature", strconv.FormatFloat(*c.settings.Temperature, 'f', -1, 64)})
 	}
-	defer resp.Body.Close()
+	if responseFormat != "" {
+		fields = append(fields, formField{"response_format", responseFormat})
+	}
+	fields = append(fields, extra...)
 
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+	header := http.Header{}
+	if c.apiKey != "" {
+		header.Set("Authorization", "Bearer "+c.apiKey)
 	}
 
-	if c.verbose || resp.StatusCode != http.StatusOK {
-		fmt.Printf("API Status: %d\n", resp.StatusCode)
+	statusCode, respBody, err := postForm(ctx, formRequest{
+		url:      c.settings.BaseURL + "/audio/transcriptions",
+		header:   header,
+		audio:    r,
+		filename: filename,
+		fields:   fields,
+		timeout:  30 * time.Second,
+		check:    checkOpenAIResponse,
+		retry:    c.retry,
+	})
+
+	if statusCode != 0 && (c.verbose || statusCode != http.StatusOK) {
+		fmt.Printf("API Status: %d\n", statusCode)
 		if len(respBody) > 0 {
 			fmt.Printf("API Response: %s\n", string(respBody))
 		}
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		if resp.StatusCode == http.StatusUnauthorized {
-			return "", fmt.Errorf("Invalid API Key")
-		}
-		if resp.StatusCode == http.StatusTooManyRequests {
-			return "", fmt.Errorf("Rate Limit Exceeded")
-		}
-		return "", fmt.Errorf("API Error: %d", resp.StatusCode)
+	if err != nil {
+		return nil, err
+	}
+	return respBody, nil
+}
+
+// checkOpenAIResponse classifies an error response of the API, which carries
+// {"error": {"message": ..., "type": ..., "code": ...}}.
+func checkOpenAIResponse(statusCode int, body []byte) error {
+	if statusCode == http.StatusOK {
+		return nil
 	}
 
 	var result transcriptionResponse
-	if err := json.Unmarshal(respBody, &result); err != nil {
-		return "", fmt.Errorf("failed to parse response: %w", err)
+	if err := json.Unmarshal(body, &result); err != nil || result.Error == nil {
+		return newStatusError(statusCode, responseMessage(body))
 	}
 
-	if result.Error != nil {
-		return "", fmt.Errorf("API returned error: %s", result.Error.Message)
+	apiErr := newStatusError(statusCode, result.Error.Message)
+	// An exhausted quota is reported with 429 like a rate limit, but waiting does not help
+	if result.Error.Type == "insufficient_quota" || result.Error.Code == "insufficient_quota" {
+		apiErr.Kind = ErrQuotaExhausted
 	}
-
-	return result.Text, nil
+	return apiErr
 }
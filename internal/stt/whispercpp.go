@@ -0,0 +1,148 @@
+package stt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"wkey/internal/config"
+)
+
+func init() {
+	Register("whisper_cpp", func(cfg *config.Config, opts Options) (Transcriber, error) {
+		client, err := NewWhisperCppClient(cfg.STT.WhisperCpp.URL, cfg.Language, opts.Verbose)
+		if err != nil {
+			return nil, err
+		}
+		client.retry = newRetryPolicy(cfg.STT.Retry, opts.OnRetry)
+		return client, nil
+	})
+}
+
+// WhisperCppClient transcribes audio with a locally running whisper.cpp server,
+// so that no audio leaves the machine.
+type WhisperCppClient struct {
+	url      string
+	language string
+	verbose  bool
+	retry    retryPolicy
+}
+
+// NewWhisperCppClient creates a client for the whisper.cpp server listening at serverURL
+// (e.g. "http://127.0.0.1:8080"). Requests are sent to its /inference endpoint.
+func NewWhisperCppClient(serverURL string, language string, verbose bool) (*WhisperCppClient, error) {
+	if serverURL == "" {
+		return nil, fmt.Errorf("whisper.cpp server URL is missing. Please set stt.whisper_cpp.url in ~/.config/wkey/config.json")
+	}
+
+	return &WhisperCppClient{
+		url:      strings.TrimSuffix(serverURL, "/") + "/inference",
+		language: language,
+		verbose:  verbose,
+	}, nil
+}
+
+type whisperCppResponse struct {
+	Text  string `json:"text"`
+	Error string `json:"error,omitempty"`
+}
+
+func (c *WhisperCppClient) Transcribe(ctx context.Context, filename string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to open audio file: %w", err)
+	}
+	defer file.Close()
+
+	return c.TranscribeStream(ctx, file, filename)
+}
+
+// TranscribeStream uploads audio while it is read from r, so a recording can be
+// sent while it is still in progress. filename names the upload and its format.
+func (c *WhisperCppClient) TranscribeStream(ctx context.Context, r io.Reader, filename string) (string, error) {
+	respBody, err := c.post(ctx, r, filename, "json")
+	if err != nil {
+		return "", err
+	}
+
+	var result whisperCppResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	// whisper.cpp keeps the leading space and trailing newline of its segments
+	return strings.TrimSpace(result.Text), nil
+}
+
+// TranscribeTimestamps transcribes a file with segment and word timestamps.
+func (c *WhisperCppClient) TranscribeTimestamps(ctx context.Context, filename string) (*Transcript, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audio file: %w", err)
+	}
+	defer file.Close()
+
+	respBody, err := c.post(ctx, file, filename, "verbose_json")
+	if err != nil {
+		return nil, err
+	}
+	return parseVerboseJSON(respBody)
+}
+
+// post sends an inference request asking for responseFormat and returns the
+// body of a successful response.
+func (c *WhisperCppClient) post(ctx context.Context, r io.Reader, filename string, responseFormat string) ([]byte, error) {
+	if c.verbose {
+		fmt.Printf("Transcribing %s (Language: %s, Server: %s)\n", filename, c.language, c.url)
+	}
+
+	fields := []formField{{"response_format", responseFormat}}
+	if c.language != "" {
+		fields = append(fields, formField{"language", c.language})
+	}
+
+	statusCode, respBody, err := postForm(ctx, formRequest{
+		url:      c.url,
+		audio:    r,
+		filename: filename,
+		fields:   fields,
+		// Local inference on CPU can be much slower than the hosted API
+		timeout: 120 * time.Second,
+		check:   checkWhisperCppResponse,
+		retry:   c.retry,
+	})
+
+	if statusCode != 0 && (c.verbose || statusCode != http.StatusOK) {
+		fmt.Printf("Server Status: %d\n", statusCode)
+		if len(respBody) > 0 {
+			fmt.Printf("Server Response: %s\n", string(respBody))
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("whisper.cpp server: %w", err)
+	}
+	return respBody, nil
+}
+
+// checkWhisperCppResponse classifies an error response of the server. whisper.cpp
+// reports failures as {"error": "..."}, sometimes with a 200 status.
+func checkWhisperCppResponse(statusCode int, body []byte) error {
+	var result whisperCppResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		if statusCode != http.StatusOK {
+			return newStatusError(statusCode, responseMessage(body))
+		}
+		// A malformed success response is reported by the caller parsing it
+		return nil
+	}
+	if result.Error != "" || statusCode != http.StatusOK {
+		return newStatusError(statusCode, result.Error)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package stt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// whisperCppServer stands in for the whisper.cpp server, answering every
+// /inference request with status and body. The last request is kept in *got.
+func whisperCppServer(t *testing.T, status int, body string, got **http.Request) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inference" {
+			t.Errorf("request to %s, want /inference", r.URL.Path)
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("failed to parse form: %v", err)
+		}
+		*got = r
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func testAudioFile(t *testing.T) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "audio.wav")
+	if err := os.WriteFile(filename, []byte("RIFF fake audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestWhisperCppFormFields(t *testing.T) {
+	var req *http.Request
+	url := whisperCppServer(t, http.StatusOK, `{"text":" Hello world.\n"}`, &req)
+	client, err := NewWhisperCppClient(url+"/", "en", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text, err := client.Transcribe(context.Background(), testAudioFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Hello world." {
+		t.Errorf("text = %q, want whitespace trimmed %q", text, "Hello world.")
+	}
+
+	for field, want := range map[string]string{"response_format": "json", "language": "en"} {
+		if got := req.FormValue(field); got != want {
+			t.Errorf("form field %s = %q, want %q", field, got, want)
+		}
+	}
+	file, header, err := req.FormFile("file")
+	if err != nil {
+		t.Fatalf("no file in form: %v", err)
+	}
+	defer file.Close()
+	data, _ := io.ReadAll(file)
+	if header.Filename != "audio.wav" || string(data) != "RIFF fake audio" {
+		t.Errorf("file = %s %q, want audio.wav with the audio", header.Filename, data)
+	}
+	if got := header.Header.Get("Content-Type"); got != "audio/wav" {
+		t.Errorf("file content type = %q, want audio/wav", got)
+	}
+}
+
+func TestWhisperCppErrorWithStatusOK(t *testing.T) {
+	var req *http.Request
+	url := whisperCppServer(t, http.StatusOK, `{"error":"failed to read WAV file"}`, &req)
+	client, err := NewWhisperCppClient(url, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Transcribe(context.Background(), testAudioFile(t))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, ErrBadRequest) {
+		t.Fatalf("err = %v, want a rejected request", err)
+	}
+	if apiErr.Message != "failed to read WAV file" {
+		t.Errorf("message = %q, want the error of the server", apiErr.Message)
+	}
+	if req.FormValue("language") != "" {
+		t.Errorf("language sent without being configured")
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"image/color"
 	"math"
-	"math/rand"
+	"sync"
 	"time"
 
 	"wkey/internal/config"
@@ -14,19 +14,26 @@ import (
 	"fyne.io/fyne/v2/canvas"
 	"fyne.io/fyne/v2/container"
 	"fyne.io/fyne/v2/layout"
+	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 )
 
 type UI struct {
-	app          fyne.App
-	window       fyne.Window
-	status       *widget.Label
-	indicator    *canvas.Circle
-	visualizer   *fyne.Container
-	bars         []*canvas.LinearGradient
-	stopAnim     chan bool
-	currentLevel float64
-	config       *config.Config
+	app        fyne.App
+	window     fyne.Window
+	status     *widget.Label
+	indicator  *canvas.Circle
+	elapsed    *canvas.Text
+	visualizer *fyne.Container
+	bars       []*canvas.LinearGradient
+	peaks      []*canvas.Rectangle // peak-hold markers above the bars
+	animMu     sync.Mutex
+	stopAnim   chan struct{} // closed to stop the running visualizer, nil if none
+	specMu     sync.Mutex
+	spectrum   []float64 // latest band levels from the recorder
+	config     *config.Config
+	cancelMu   sync.Mutex
+	onCancel   func()
 }
 
 func New(cfg *config.Config) *UI {
@@ -42,17 +49,16 @@ func New(cfg *config.Config) *UI {
 	indicator := canvas.NewCircle(color.RGBA{R: 200, G: 200, B: 200, A: 255})
 	indicator.Resize(fyne.NewSize(10, 10))
 
+	elapsed := canvas.NewText("", theme.Color(theme.ColorNameForeground))
+	elapsed.TextSize = theme.CaptionTextSize()
+
 	// Visualizer Bars
 	numBars := cfg.Visual.BarCount
 	bars := make([]*canvas.LinearGradient, numBars)
+	peaks := make([]*canvas.Rectangle, numBars)
 
 	// Manual layout parameters
-	maxH := float32(50)
-	barW := float32(200 / float32(numBars)) // Scale bar width based on count
-	if barW < 2 {
-		barW = 2
-	}
-	gap := float32(1)
+	barW, gap := barGeometry(numBars)
 
 	// Use WithoutLayout for manual positioning
 	visContainer := container.NewWithoutLayout()
@@ -74,8 +80,14 @@ func New(cfg *config.Config) *UI {
 		grad.Resize(fyne.NewSize(barW, 2))
 		grad.Move(fyne.NewPos(x, maxH-2))
 
+		peak := canvas.NewRectangle(endColor)
+		peak.Resize(fyne.NewSize(barW, 2))
+		peak.Hide()
+
 		bars[i] = grad
+		peaks[i] = peak
 		visContainer.Add(grad)
+		visContainer.Add(peak)
 	}
 
 	// Wrap visualizer in a container that gives it some height and centering
@@ -83,138 +95,172 @@ func New(cfg *config.Config) *UI {
 	visWrapper.Resize(fyne.NewSize(240, 60)) // Adjusted height for padding
 
 	content := container.New(layout.NewVBoxLayout(),
-		container.NewCenter(indicator),
+		container.NewCenter(container.NewHBox(
+			container.NewGridWrap(fyne.NewSize(10, 10), indicator),
+			elapsed,
+		)),
 		status,
 		visWrapper,
 	)
 	w.SetContent(content)
 	w.CenterOnScreen()
 
-	return &UI{
+	u := &UI{
 		app:        a,
 		window:     w,
 		status:     status,
 		indicator:  indicator,
+		elapsed:    elapsed,
 		visualizer: visContainer,
 		bars:       bars,
-		stopAnim:   make(chan bool),
+		peaks:      peaks,
 		config:     cfg,
 	}
+
+	// Escape aborts the session when the window happens to have focus
+	w.Canvas().SetOnTypedKey(func(ev *fyne.KeyEvent) {
+		if ev.Name == fyne.KeyEscape {
+			u.cancel()
+		}
+	})
+
+	return u
+}
+
+// HideOnClose makes closing the window cancel the session and hide the window
+// instead of quitting the app, for a resident daemon.
+func (u *UI) HideOnClose() {
+	u.window.SetCloseIntercept(func() {
+		u.cancel()
+		u.window.Hide()
+	})
+}
+
+// SetOnCancel registers the function called when the user presses Escape in the window.
+// It may be called from any goroutine.
+func (u *UI) SetOnCancel(fn func()) {
+	u.cancelMu.Lock()
+	u.onCancel = fn
+	u.cancelMu.Unlock()
+}
+
+// cancel calls the function registered with SetOnCancel, if any.
+func (u *UI) cancel() {
+	u.cancelMu.Lock()
+	onCancel := u.onCancel
+	u.cancelMu.Unlock()
+	if onCancel != nil {
+		onCancel()
+	}
+}
+
+// Visualizer layout and dynamics
+const (
+	maxH       = float32(50) // visualizer height
+	barMaxH    = 45.0        // height of a full scale bar
+	attack     = 0.6         // fraction of a rise shown per frame
+	release    = 0.15        // fraction of a fall shown per frame, scaled by animation_speed
+	peakHold   = 500 * time.Millisecond
+	peakFall   = 0.02 // per frame, scaled by animation_speed
+	minBarFrac = 0.04 // keeps silent bars visible
+)
+
+// barGeometry returns the bar width and gap for numBars bars.
+func barGeometry(numBars int) (float32, float32) {
+	barW := float32(200 / float32(numBars)) // Scale bar width based on count
+	if barW < 2 {
+		barW = 2
+	}
+	return barW, 1
 }
 
 func (u *UI) startVisualizer() {
 	// Stop existing if any (though we usually stop before start)
+	u.stopVisualizer()
+	stop := make(chan struct{})
+	u.animMu.Lock()
+	u.stopAnim = stop
+	u.animMu.Unlock()
+
 	go func() {
 		ticker := time.NewTicker(30 * time.Millisecond) // Faster update for smoothness
 		defer ticker.Stop()
 
-		t := 0.0
-
-		// Initialize random offsets for each bar
-		rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 		numBars := len(u.bars)
-		offsets := make([]float64, numBars)
-		for i := 0; i < numBars; i++ {
-			offsets[i] = 0.1 + rng.Float64()*1.3 // Random between 0.1 and 1.4
-		}
+		barW, gap := barGeometry(numBars)
+		speed := u.config.Visual.AnimationSpeed
 
-		// Layout constants
-		maxH := float32(50)
-		barW := float32(200 / float32(numBars))
-		if barW < 2 {
-			barW = 2
-		}
-		gap := float32(1)
-
-		startColor := parseHexColor(u.config.Visual.BarColorStart)
-		endColor := parseHexColor(u.config.Visual.BarColorEnd)
+		// Smoothed band levels and their held peaks
+		levels := make([]float64, numBars)
+		peaks := make([]float64, numBars)
+		peakUntil := make([]time.Time, numBars)
 
 		for {
 			select {
-			case <-u.stopAnim:
+			case <-stop:
 				// Reset bars
 				fyne.Do(func() {
 					for i, b := range u.bars {
 						x := float32(i) * (barW + gap)
 						b.Resize(fyne.NewSize(barW, 2))
 						b.Move(fyne.NewPos(x, maxH-2))
-						b.StartColor = startColor
-						b.EndColor = endColor
 						b.Refresh()
+						u.peaks[i].Hide()
 					}
 				})
+				u.specMu.Lock()
+				u.spectrum = nil
+				u.specMu.Unlock()
 				return
-			case <-ticker.C:
-				t += 0.1 * u.config.Visual.AnimationSpeed
-				fyne.Do(func() {
-					numBars := len(u.bars)
-					center := float64(numBars) / 2.0
-
-					for i, b := range u.bars {
-						// Symmetric wave simulation
-						dist := math.Abs(float64(i) - center)
-
-						// Combine sine waves for a "tech" feel
-						// Base wave
-						h1 := math.Sin(t + dist*0.5)
-						// Secondary faster wave
-						h2 := math.Sin(t*2.0 - dist*0.8)
-						// Third wave for more variation
-						h3 := math.Cos(t*1.5 + dist*0.3)
-
-						// Normalize and scale
-						val := (h1 + h2 + h3 + 3) / 6.0 // 0.0 to 1.0 approx
-
-						// Add some randomness for "noise" but keep it smooth-ish
-						noise := (rng.Float64() - 0.5) * 0.2 // Reduced noise
-						val += noise
-
-						// Scale by audio level
-						// If silence (level < 0.01), keep it very low
-						level := u.currentLevel * 3.0 // Boost sensitivity
-						if level > 1.0 {
-							level = 1.0
-						}
-						if level < 0.05 {
-							level = 0.05 // Minimum "alive" hum
-						}
-						val *= level
-
-						// Add a center bias so middle bars are generally higher
-						centerBias := 1.0 - (dist / center * 0.5)
-						val *= centerBias
-
-						// Apply persistent random offset
-						val *= offsets[i]
-
-						// Non-linear scaling to accentuate peaks and suppress lows
-						val = val * val
+			case now := <-ticker.C:
+				u.specMu.Lock()
+				target := u.spectrum
+				u.specMu.Unlock()
+
+				for i := range levels {
+					var v float64
+					if i < len(target) {
+						v = target[i]
+					}
+					// Rise fast so onsets are visible, fall slowly so bars do not flicker
+					if v > levels[i] {
+						levels[i] += (v - levels[i]) * attack
+					} else {
+						levels[i] += (v - levels[i]) * math.Min(release*speed, 1)
+					}
 
-						if val < 0.05 {
-							val = 0.05
-						}
-						if val > 1.0 {
-							val = 1.0
-						}
+					if levels[i] >= peaks[i] {
+						peaks[i] = levels[i]
+						peakUntil[i] = now.Add(peakHold)
+					} else if now.After(peakUntil[i]) {
+						peaks[i] = math.Max(peaks[i]-peakFall*speed, levels[i])
+					}
+				}
 
-						height := float32(val * 45.0) // Max height 45
+				// The UI thread draws asynchronously, so hand it a snapshot
+				levels, peaks := append([]float64(nil), levels...), append([]float64(nil), peaks...)
+				fyne.Do(func() {
+					for i, b := range u.bars {
+						height := float32(math.Max(levels[i], minBarFrac) * barMaxH)
 						if height < 2 {
 							height = 2
 						}
-						if height > maxH {
-							height = maxH
-						}
 
 						// Manual layout update
 						x := float32(i) * (barW + gap)
 						b.Resize(fyne.NewSize(barW, height))
 						b.Move(fyne.NewPos(x, maxH-height))
-
-						// Apply colors from config
-						b.StartColor = startColor
-						b.EndColor = endColor
-
 						b.Refresh()
+
+						peak := u.peaks[i]
+						if peaks[i] > minBarFrac {
+							peakY := maxH - float32(peaks[i]*barMaxH) - 2
+							peak.Move(fyne.NewPos(x, peakY))
+							peak.Show()
+						} else {
+							peak.Hide()
+						}
+						peak.Refresh()
 					}
 					u.visualizer.Refresh()
 				})
@@ -232,20 +278,42 @@ func parseHexColor(s string) color.Color {
 }
 
 func (u *UI) stopVisualizer() {
-	// Non-blocking send
-	select {
-	case u.stopAnim <- true:
-	default:
+	u.animMu.Lock()
+	defer u.animMu.Unlock()
+	if u.stopAnim != nil {
+		close(u.stopAnim)
+		u.stopAnim = nil
 	}
 }
 
-func (u *UI) SetAudioLevel(level float64) {
-	u.currentLevel = level
+// SetSpectrum updates the frequency band levels (0.0-1.0) shown by the visualizer.
+func (u *UI) SetSpectrum(levels []float64) {
+	u.specMu.Lock()
+	u.spectrum = append([]float64(nil), levels...)
+	u.specMu.Unlock()
+}
+
+// SetElapsed shows the length of the recording so far.
+func (u *UI) SetElapsed(d time.Duration) {
+	d = d.Truncate(time.Second)
+	text := fmt.Sprintf("%d:%02d", int(d.Minutes()), int(d.Seconds())%60)
+	fyne.Do(func() {
+		u.elapsed.Text = text
+		u.elapsed.Refresh()
+	})
+}
+
+// recordingPrompt tells the user how to end the recording.
+func (u *UI) recordingPrompt() string {
+	if u.config.Mode == "hold" {
+		return "Release key to stop"
+	}
+	return "Execute again to stop" // Clear text for cleaner UI
 }
 
 func (u *UI) ShowRecording() {
 	fyne.Do(func() {
-		u.status.SetText("Execute again to stop") // Clear text for cleaner UI
+		u.status.SetText(u.recordingPrompt())
 		u.indicator.FillColor = color.RGBA{R: 255, G: 0, B: 0, A: 255} // Red
 		u.indicator.Refresh()
 		u.window.Show()
@@ -253,6 +321,31 @@ func (u *UI) ShowRecording() {
 	u.startVisualizer()
 }
 
+// ShowWarning reports a problem with the microphone input while recording,
+// or restores the recording prompt once warning is empty.
+func (u *UI) ShowWarning(warning string) {
+	fyne.Do(func() {
+		if warning == "" {
+			u.status.SetText(u.recordingPrompt())
+			u.indicator.FillColor = color.RGBA{R: 255, G: 0, B: 0, A: 255} // Red
+		} else {
+			u.status.SetText(warning)
+			u.indicator.FillColor = color.RGBA{R: 255, G: 215, B: 0, A: 255} // Yellow
+		}
+		u.indicator.Refresh()
+	})
+}
+
+// ShowPaused shows that the recording is paused until it is resumed.
+func (u *UI) ShowPaused() {
+	u.stopVisualizer()
+	fyne.Do(func() {
+		u.status.SetText("Paused")
+		u.indicator.FillColor = color.RGBA{R: 0, G: 120, B: 255, A: 255} // Blue
+		u.indicator.Refresh()
+	})
+}
+
 func (u *UI) ShowTranscribing() {
 	u.stopVisualizer()
 	fyne.Do(func() {
@@ -262,6 +355,13 @@ func (u *UI) ShowTranscribing() {
 	})
 }
 
+// ShowRetrying tells the user that transcription is being attempted again.
+func (u *UI) ShowRetrying(attempt, maxAttempts int) {
+	fyne.Do(func() {
+		u.status.SetText(fmt.Sprintf("Transcribing... (attempt %d/%d)", attempt, maxAttempts))
+	})
+}
+
 func (u *UI) ShowDone(text string) {
 	u.stopVisualizer()
 	fyne.Do(func() {
@@ -271,6 +371,15 @@ func (u *UI) ShowDone(text string) {
 	})
 }
 
+func (u *UI) ShowCancelled(reason string) {
+	u.stopVisualizer()
+	fyne.Do(func() {
+		u.status.SetText(reason)
+		u.indicator.FillColor = color.RGBA{R: 128, G: 128, B: 128, A: 255} // Gray
+		u.indicator.Refresh()
+	})
+}
+
 func (u *UI) ShowError(err string) {
 	u.stopVisualizer()
 	fyne.Do(func() {